@@ -6,18 +6,27 @@ import (
 	"GoRelay/internal/loadbalancer/usecase"
 	"GoRelay/internal/models"
 	"GoRelay/internal/server"
+	"GoRelay/pkg/certs"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/events"
 	"GoRelay/pkg/logger"
+	"GoRelay/pkg/utils"
 	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 func main() {
 	log := logger.NewLogger()
-	cfg_repo := repository.NewConfigRepository("configs/config.yaml", log)
+	cfg_repo := repository.NewConfigRepository("configs/config.yaml", log, usecase.BalancerNames())
 
 	cfg, err := cfg_repo.Load()
 	if err != nil {
@@ -25,34 +34,242 @@ func main() {
 		os.Exit(1)
 	}
 
-	health_repo := repository.NewHealthRepository(log)
+	affinity, err := usecase.AffinityPolicyFromConfig(cfg.Affinity)
+	if err != nil {
+		log.Error("Error while setting up session affinity", "error", err)
+		os.Exit(1)
+	}
+	if affinity != nil && cfg.Affinity.Secret == "" {
+		log.Warn("affinity.secret is not set, using a random one: affinity cookies will not survive a restart")
+	}
 
-	pool := models.NewServerPool()
-	for _, b := range cfg.Backends {
-		backend, err := models.NewBackend(b)
+	trusted_proxies, err := clientip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		log.Error("Error while parsing trusted proxies", "error", err)
+		os.Exit(1)
+	}
+
+	// The top-level backends form the default pool, next to the named ones
+	pool_cfgs := make(map[string]utils.PoolConfig, len(cfg.Pools)+1)
+	for name, pc := range cfg.Pools {
+		pool_cfgs[name] = pc
+	}
+	if len(cfg.Backends) > 0 {
+		pool_cfgs[utils.DefaultPool] = utils.PoolConfig{
+			Backends:       cfg.Backends,
+			Algorithm:      cfg.Algorithm,
+			HealthInterval: cfg.HealthInterval,
+			Hash:           cfg.Hash,
+			HeaderRules:    cfg.HeaderRules,
+			TLS:            cfg.UpstreamTLS,
+			Protocol:       cfg.UpstreamProtocol,
+			HealthCheck:    cfg.HealthCheck,
+		}
+	}
+
+	// Shared by all pools so shutdown drains every upgraded connection at once
+	upgrades := usecase.NewUpgradeTracker()
+	upgrade_policy := usecase.UpgradePolicyFromConfig(cfg.Upgrade)
+
+	// Stops the certificate reload watchers of the upstream transports and the listeners
+	watch_ctx, stop_watching := context.WithCancel(context.Background())
+	defer stop_watching()
+
+	// Health transitions are logged and counted; both subscribers end when the bus is closed
+	health_events := events.NewBus[usecase.HealthEvent]()
+	log_events, _ := health_events.Subscribe(64)
+	metric_events, _ := health_events.Subscribe(64)
+	go usecase.LogHealthEvents(log_events, log)
+	go usecase.RecordHealthMetrics(metric_events)
+
+	// One scheduler for every pool, so the probe concurrency limit is global
+	health_scheduler := usecase.NewHealthScheduler(
+		usecase.WithProbeConcurrency(cfg.HealthScheduler.MaxConcurrent),
+		usecase.WithProbeJitter(cfg.HealthScheduler.Jitter),
+		usecase.WithHealthEvents(health_events),
+	)
+	health_ctx, stop_health := context.WithCancel(context.Background())
+	defer stop_health()
+
+	pools := make(map[string]*usecase.LoadBalancerUseCase, len(pool_cfgs))
+	for name, pc := range pool_cfgs {
+		pool, err := newServerPool(pc.Backends)
 		if err != nil {
-			log.Error("Error while getting new backend", "url", b)
+			log.Error("Error while getting new backend", "pool", name, "error", err)
 			os.Exit(1)
 		}
-		pool.AddBackend(backend)
+		algorithm, interval, hash := pc.Algorithm, pc.HealthInterval, pc.Hash
+		if algorithm == "" {
+			algorithm = cfg.Algorithm
+		}
+		if interval == 0 {
+			interval = cfg.HealthInterval
+		}
+		if hash == (utils.HashConfig{}) {
+			hash = cfg.Hash
+		}
+		headers, err := usecase.HeaderRulesFromConfig(pc.HeaderRules)
+		if err != nil {
+			log.Error("Error while loading header rules", "pool", name, "error", err)
+			os.Exit(1)
+		}
+		transport, err := newTransport(watch_ctx, pc.TLS, pc.Protocol, func(err error) {
+			log.Error("client certificate reload failed, keeping the previous certificate", "pool", name, "error", err)
+		})
+		if err != nil {
+			log.Error("Error while setting up upstream TLS", "pool", name, "error", err)
+			os.Exit(1)
+		}
+		if pc.TLS.InsecureSkipVerify {
+			log.Warn("!!! TLS certificate verification is DISABLED for this pool: backends are not authenticated and traffic can be intercepted !!!", "pool", name)
+		}
+		health_check, err := repository.HealthCheckFromConfig(pc.HealthCheck)
+		if err != nil {
+			log.Error("Error while setting up health checks", "pool", name, "error", err)
+			os.Exit(1)
+		}
+		health_repo := repository.NewHealthRepository(log, repository.WithTransport(transport), repository.WithHealthCheck(health_check))
+		uc := usecase.NewLoadBalancerUseCase(pool, algorithm, health_repo, transport,
+			usecase.WithFlushInterval(cfg.FlushInterval),
+			usecase.WithRetryPolicy(usecase.RetryPolicyFromConfig(cfg.Retry)),
+			usecase.WithOutlierDetection(usecase.OutlierDetectionFromConfig(cfg.OutlierDetection)),
+			usecase.WithHashPolicy(usecase.HashPolicyFromConfig(hash)),
+			usecase.WithAffinity(affinity.ForPool(name)),
+			usecase.WithEWMADecay(cfg.EWMADecay),
+			usecase.WithTrustedProxies(trusted_proxies),
+			usecase.WithHeaderRules(headers),
+			usecase.WithUpgrades(upgrade_policy, upgrades),
+			usecase.WithHealthThresholds(pc.HealthCheck.Rise, pc.HealthCheck.Fall),
+			usecase.WithFlapDetection(pc.HealthCheck.Flap.Window, pc.HealthCheck.Flap.Transitions, pc.HealthCheck.Flap.Hold),
+		)
+		pools[name] = uc
+
+		health_scheduler.Schedule(name, uc, interval, pc.HealthCheck.UnhealthyInterval)
+		go uc.StartOutlierDetectionWithContext(health_ctx)
 	}
 
-	transport := &http.Transport{}
-	uc := usecase.NewLoadBalancerUseCase(pool, cfg.Algorithm, health_repo, transport)
+	health_done := make(chan struct{})
+	go func() {
+		health_scheduler.Run(health_ctx)
+		close(health_done)
+	}()
 
-	go uc.StartHealthChecksWithContext(context.Background(), cfg.HealthInterval)
+	routes, err := usecase.RoutesFromConfig(cfg.Routes)
+	if err != nil {
+		log.Error("Error while loading routes", "error", err)
+		os.Exit(1)
+	}
+	// tcp:// and udp:// pools are only for raw listeners, HTTP requests can't be routed to them
+	http_pools := make(map[string]*usecase.LoadBalancerUseCase, len(pools))
+	for name, uc := range pools {
+		if !utils.HasRawBackends(pool_cfgs[name].Backends) {
+			http_pools[name] = uc
+		}
+	}
+	router, err := usecase.NewRouter(http_pools, routes)
+	if err != nil {
+		log.Error("Error while building the routing table", "error", err)
+		os.Exit(1)
+	}
 
-	h := handler.NewHandler(uc, log)
+	h := handler.NewHandler(router, log, handler.WithClientIPResolver(trusted_proxies))
 	route_cfg := handler.NewRouteConfig(h)
-	srv := server.NewServer(route_cfg, log)
+	srv := server.NewServer(route_cfg, log, server.WithH2C(cfg.H2C))
 
-	go func() {
-		if err := srv.Start(cfg.Port); err != nil && err != http.ErrServerClosed {
-			log.Error("skill issue", "error", err)
+	if cfg.Port != "" {
+		listen_opts, err := listenOptions(cfg.ProxyProtocol)
+		if err != nil {
+			log.Error("Error while parsing PROXY protocol sources", "error", err)
 			os.Exit(1)
 		}
-	}()
-	log.Info("server started", "port", cfg.Port)
+		go func() {
+			if err := srv.Start(cfg.Port, listen_opts...); err != nil && err != http.ErrServerClosed {
+				log.Error("skill issue", "error", err)
+				os.Exit(1)
+			}
+		}()
+		log.Info("server started", "port", cfg.Port)
+	}
+
+	if cfg.MetricsAddress != "" {
+		go func() {
+			if err := srv.ServeMetrics(cfg.MetricsAddress); err != nil && err != http.ErrServerClosed {
+				log.Error("metrics listener failed", "address", cfg.MetricsAddress, "error", err)
+				os.Exit(1)
+			}
+		}()
+		log.Info("metrics listener started", "address", cfg.MetricsAddress)
+	}
+
+	for _, l := range cfg.Listeners {
+		if l.Name == "" {
+			l.Name = l.Address
+		}
+		pool_name := l.Pool
+		if pool_name == "" {
+			pool_name = utils.DefaultPool
+		}
+		listen_opts, err := listenOptions(l.ProxyProtocol)
+		if err != nil {
+			log.Error("Error while parsing PROXY protocol sources", "listener", l.Name, "error", err)
+			os.Exit(1)
+		}
+		if l.Protocol == "udp" {
+			udp_proxy := usecase.NewUDPProxy(pools[pool_name],
+				usecase.WithUDPSessionTimeout(l.IdleTimeout),
+				usecase.WithUDPMaxSessions(l.MaxSessions),
+			)
+			go func() {
+				if err := srv.ServeUDP(l.Address, udp_proxy); err != nil && err != http.ErrServerClosed {
+					log.Error("listener failed", "listener", l.Name, "address", l.Address, "error", err)
+					os.Exit(1)
+				}
+			}()
+			log.Info("UDP listener started", "listener", l.Name, "address", l.Address, "pool", pool_name)
+			continue
+		}
+		if l.Protocol == "tcp" {
+			tcp_proxy := usecase.NewTCPProxy(pools[pool_name],
+				usecase.WithConnectTimeout(l.ConnectTimeout),
+				usecase.WithConnectAttempts(l.ConnectAttempts),
+				usecase.WithTCPIdleTimeout(l.IdleTimeout),
+				usecase.WithSendProxyProtocol(proxyProtocolVersion(l.SendProxyProtocol)),
+			)
+			go func() {
+				if err := srv.ServeTCP(l.Address, tcp_proxy, listen_opts...); err != nil && err != http.ErrServerClosed {
+					log.Error("listener failed", "listener", l.Name, "address", l.Address, "error", err)
+					os.Exit(1)
+				}
+			}()
+			log.Info("TCP listener started", "listener", l.Name, "address", l.Address, "pool", pool_name)
+			continue
+		}
+		if l.TLS == nil {
+			go func() {
+				if err := srv.Listen(l.Address, l.H2C, listen_opts...); err != nil && err != http.ErrServerClosed {
+					log.Error("listener failed", "listener", l.Name, "address", l.Address, "error", err)
+					os.Exit(1)
+				}
+			}()
+			log.Info("listener started", "listener", l.Name, "address", l.Address)
+			continue
+		}
+		tls_cfg, store, err := server.NewTLSConfig(l.Name, *l.TLS)
+		if err != nil {
+			log.Error("Error while setting up TLS", "listener", l.Name, "error", err)
+			os.Exit(1)
+		}
+		go store.Watch(watch_ctx, server.ReloadInterval(*l.TLS), func(err error) {
+			log.Error("certificate reload failed, keeping the previous certificate", "listener", l.Name, "error", err)
+		})
+		go func() {
+			if err := srv.StartTLS(l.Address, tls_cfg, listen_opts...); err != nil && err != http.ErrServerClosed {
+				log.Error("listener failed", "listener", l.Name, "address", l.Address, "error", err)
+				os.Exit(1)
+			}
+		}()
+		log.Info("TLS listener started", "listener", l.Name, "address", l.Address)
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -63,9 +280,110 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// The server forgets hijacked connections, WebSockets get their own grace period alongside
+	drain_ctx, cancel_drain := context.WithTimeout(context.Background(), upgrade_policy.DrainTimeout)
+	defer cancel_drain()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- upgrades.Drain(drain_ctx)
+	}()
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error("server forced to shutdown", "error", err)
 	}
+	if err := <-drained; err != nil {
+		log.Warn("closed upgraded connections still open after the drain timeout", "error", err)
+	}
+	stop_health()
+	<-health_done
+	health_events.Close()
 
 	log.Info("server exited gracefully")
 }
+
+// listenOptions expects PROXY protocol headers from the given sources, none when empty.
+func listenOptions(proxy_protocol []string) ([]server.ListenOption, error) {
+	if len(proxy_protocol) == 0 {
+		return nil, nil
+	}
+	trusted, err := clientip.NewResolver(proxy_protocol)
+	if err != nil {
+		return nil, err
+	}
+	return []server.ListenOption{server.WithProxyProtocol(trusted)}, nil
+}
+
+// proxyProtocolVersion maps "v1" and "v2" to their version, anything else to none.
+func proxyProtocolVersion(version string) int {
+	switch version {
+	case "v1":
+		return 1
+	case "v2":
+		return 2
+	}
+	return 0
+}
+
+func newServerPool(backends []utils.BackendConfig) (*models.ServerPool, error) {
+	pool := models.NewServerPool()
+	for _, b := range backends {
+		backend, err := models.NewBackend(b.URL)
+		if err != nil {
+			return nil, err
+		}
+		backend.SetWeight(b.Weight)
+		pool.AddBackend(backend)
+	}
+	return pool, nil
+}
+
+// newTransport builds a pool's own transport, so TLS settings, protocol and connection reuse stay per pool.
+func newTransport(ctx context.Context, cfg utils.UpstreamTLSConfig, protocol string, onReloadError func(error)) (http.RoundTripper, error) {
+	if protocol == "h2c" {
+		if cfg != (utils.UpstreamTLSConfig{}) {
+			return nil, errors.New("upstream TLS settings can't be used with the h2c protocol")
+		}
+		// Prior-knowledge HTTP/2 over plain TCP, the TLS dial hook is what http2.Transport uses for every connection
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}, nil
+	}
+	transport := &http.Transport{}
+	switch protocol {
+	case "http1":
+		// A non-nil empty map keeps HTTP/2 from being negotiated
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "h2":
+		transport.ForceAttemptHTTP2 = true
+	}
+	if cfg == (utils.UpstreamTLSConfig{}) {
+		return transport, nil
+	}
+	var client *certs.Store
+	if cfg.CertFile != "" {
+		store, err := certs.NewStore([]certs.Pair{{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile}})
+		if err != nil {
+			return nil, err
+		}
+		go store.Watch(ctx, server.DefaultCertReloadInterval, onReloadError)
+		client = store
+	}
+	tls_cfg, err := certs.NewClientConfig(certs.ClientOptions{
+		CAFile:             cfg.CAFile,
+		Client:             client,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         cfg.MinVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tls_cfg
+	// A custom TLS config turns off HTTP/2 unless asked for explicitly
+	transport.ForceAttemptHTTP2 = protocol != "http1"
+	return transport, nil
+}
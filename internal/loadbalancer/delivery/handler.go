@@ -2,31 +2,95 @@ package handler
 
 import (
 	"GoRelay/internal/loadbalancer/usecase"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/http_errors"
 	"GoRelay/pkg/logger"
+	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 )
 
 type Handler struct {
-	uc     usecase.RequestProxy
-	logger *logger.Logger
+	uc       usecase.RequestProxy
+	logger   *logger.Logger
+	clientIP *clientip.Resolver
 }
 
-func NewHandler(uc *usecase.LoadBalancerUseCase, logger *logger.Logger) *Handler {
-	return &Handler{
+type HandlerOption func(*Handler)
+
+// WithClientIPResolver resolves the real client address once per request and stores it in the request context.
+func WithClientIPResolver(resolver *clientip.Resolver) HandlerOption {
+	return func(h *Handler) {
+		h.clientIP = resolver
+	}
+}
+
+func NewHandler(uc usecase.RequestProxy, logger *logger.Logger, opts ...HandlerOption) *Handler {
+	h := &Handler{
 		uc:     uc,
 		logger: logger,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *Handler) ProxyHandler(w http.ResponseWriter, r *http.Request) {
-	err := h.uc.HandleRequest(r, w)
-	if err != nil {
-		h.logger.Error("Error while serving Request", "error", err)
+	if h.clientIP != nil {
+		r = r.WithContext(clientip.NewContext(r.Context(), h.clientIP.Resolve(r)))
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	err := h.uc.HandleRequest(r, sw)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, http_errors.ErrNoRoute) || errors.Is(err, http_errors.ErrClientCertDenied) {
+		// Refused by policy, already answered with 404/403: nothing went wrong
+		h.logger.Info("request refused", "client_ip", clientip.FromRequest(r), "path", r.URL.Path, "reason", err)
+	} else {
+		h.logger.Error("Error while serving Request", "client_ip", clientip.FromRequest(r), "error", err)
+	}
+	if !sw.committed {
 		w.WriteHeader(http.StatusBadGateway)
 	}
 }
 
+// statusWriter notes whether a final status was sent, so an error after it doesn't write a second one.
+type statusWriter struct {
+	http.ResponseWriter
+	committed bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	// Informational responses (103 Early Hints etc.) may precede the final status
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.committed = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.committed = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack hands the connection to an upgraded protocol, after which no status may be written.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.committed = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (flushing, hijacking, deadlines).
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	healthyCount := h.uc.GetHealthyBackends()
 	if healthyCount == 0 {
@@ -92,6 +92,22 @@ func TestProxyHandler(t *testing.T) {
 		assert.Equal(t, 0, backend2.GetActiveConnections(), "Second backend should have no active connections")
 		assert.Equal(t, http.StatusOK, w.Code, "Expected status 200")
 	})
+
+	t.Run("DeniedRequestIsAnsweredOnce", func(t *testing.T) {
+		uc := &mock.LoadBalancerUseCaseMock{
+			HandleRequestFunc: func(req *http.Request, w http.ResponseWriter) error {
+				w.WriteHeader(http.StatusForbidden)
+				return http_errors.ErrClientCertDenied
+			},
+		}
+		handler := &Handler{uc: uc, logger: logger.NewLogger()}
+
+		w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+		handler.ProxyHandler(w, httptest.NewRequest("GET", "/admin", nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Code, "expected the status written by the use case")
+		assert.Equal(t, 1, w.calls, "expected no second WriteHeader")
+	})
 }
 
 func TestHealthHandler(t *testing.T) {
@@ -130,3 +146,14 @@ func TestHealthHandler(t *testing.T) {
 	})
 
 }
+
+// headerCounter counts WriteHeader calls, which httptest.ResponseRecorder silently drops after the first.
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	calls int
+}
+
+func (w *headerCounter) WriteHeader(code int) {
+	w.calls++
+	w.ResponseRecorder.WriteHeader(code)
+}
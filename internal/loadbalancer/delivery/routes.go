@@ -1,6 +1,8 @@
 package handler
 
-import "net/http"
+import (
+	"net/http"
+)
 
 type RouteConfig struct {
 	mux     *http.ServeMux
@@ -1,11 +1,26 @@
 package mock
 
-import "GoRelay/internal/models"
+import (
+	"GoRelay/internal/models"
+	"errors"
+)
 
 type HealthRepositoryMock struct {
 	CheckHealthFunc func(*models.Backend) bool
+	ProbeFunc       func(*models.Backend) error
 }
 
 func (m *HealthRepositoryMock) CheckHealth(backend *models.Backend) bool {
 	return m.CheckHealthFunc(backend)
 }
+
+// Probe uses ProbeFunc when set, otherwise CheckHealthFunc with a generic failure.
+func (m *HealthRepositoryMock) Probe(backend *models.Backend) error {
+	if m.ProbeFunc != nil {
+		return m.ProbeFunc(backend)
+	}
+	if !m.CheckHealthFunc(backend) {
+		return errors.New("check failed")
+	}
+	return nil
+}
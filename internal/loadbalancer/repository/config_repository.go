@@ -9,14 +9,17 @@ import (
 )
 
 type ConfigRepository struct {
-	filePath string
-	logger   *logger.Logger
+	filePath   string
+	logger     *logger.Logger
+	algorithms []string
 }
 
-func NewConfigRepository(filePath string, logger *logger.Logger) *ConfigRepository {
+// algorithms are the balancing algorithm names the config may use, usually usecase.BalancerNames().
+func NewConfigRepository(filePath string, logger *logger.Logger, algorithms []string) *ConfigRepository {
 	return &ConfigRepository{
-		filePath: filePath,
-		logger:   logger,
+		filePath:   filePath,
+		logger:     logger,
+		algorithms: algorithms,
 	}
 }
 
@@ -33,7 +36,7 @@ func (r *ConfigRepository) Load() (*utils.Config, error) {
 		return nil, err
 	}
 
-	err = utils.ValidateConfig(&cfg)
+	err = utils.ValidateConfig(&cfg, r.algorithms)
 	if err != nil {
 		logger.NewLogger().Error("error while valdiating config-yaml.yaml", "error", err)
 	}
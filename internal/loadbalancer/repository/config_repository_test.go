@@ -2,28 +2,34 @@ package repository
 
 import (
 	"GoRelay/pkg/logger"
+	"GoRelay/pkg/utils"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// algorithms stands in for usecase.BalancerNames, which the repository layer must not depend on.
+var algorithms = []string{"round_robin"}
+
 func TestLoad(t *testing.T) {
 	logger := logger.NewLogger()
-	var b []string
+	var b []utils.BackendConfig
 	var expected_time time.Duration
 	t.Run("valid config", func(t *testing.T) {
-		cfg_repo := NewConfigRepository("validconfig.yaml", logger)
+		cfg_repo := NewConfigRepository("validconfig.yaml", logger, algorithms)
 		cfg, err := cfg_repo.Load()
 		assert.Nil(t, err, "expected no errors for valid config")
 		assert.NotNil(t, cfg.Port, "expected not nil port")
 		assert.NotNil(t, cfg.Backends, "expected not nil backends")
-		assert.IsType(t, cfg.Backends, b, "expected backend type []string")
+		assert.IsType(t, cfg.Backends, b, "expected backend type []utils.BackendConfig")
+		assert.Equal(t, utils.BackendConfig{URL: "http://localhost:9001"}, cfg.Backends[0], "expected plain url backend")
+		assert.Equal(t, utils.BackendConfig{URL: "http://localhost:9002", Weight: 3}, cfg.Backends[1], "expected weighted backend")
 		assert.IsType(t, cfg.HealthInterval, expected_time, "expected HealthInterval type time.Duration")
 	})
 
 	t.Run("invalid yaml", func(t *testing.T) {
-		cfg_repo := NewConfigRepository("invalidconfig.yaml", logger)
+		cfg_repo := NewConfigRepository("invalidconfig.yaml", logger, algorithms)
 		cfg, err := cfg_repo.Load()
 		assert.NotNil(t, err, "expected errors for invalid yaml")
 		assert.Nil(t, cfg, "expected empty cfg")
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"GoRelay/internal/models"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// grpcHealthPath is the method of the standard gRPC health checking protocol.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// Values of HealthCheckResponse.status.
+var grpcServingStatus = map[uint64]string{0: "UNKNOWN", 1: "SERVING", 2: "NOT_SERVING", 3: "SERVICE_UNKNOWN"}
+
+/*
+checkGRPC calls grpc.health.v1.Health/Check and passes when the service reports SERVING. It needs a
+transport speaking HTTP/2 to the backend (pool protocol h2c, or TLS with h2), as every gRPC call does.
+The messages are small enough to encode by hand.
+*/
+func (r *HealthRepository) checkGRPC(ctx context.Context, backend *models.Backend) error {
+	// HealthCheckRequest{service = 1}
+	var msg []byte
+	if r.check.Service != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(r.check.Service)))...)
+		msg = append(msg, r.check.Service...)
+	}
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	target := backend.URL.ResolveReference(&url.URL{Path: grpcHealthPath})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	// Trailers are only complete once the body is read; errors come trailers-only, in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		message := resp.Trailer.Get("Grpc-Message") + resp.Header.Get("Grpc-Message")
+		return fmt.Errorf("grpc-status %q: %s", status, message)
+	}
+	serving, err := grpcHealthStatus(body)
+	if err != nil {
+		return err
+	}
+	if serving != 1 {
+		return fmt.Errorf("service %q is %s", r.check.Service, grpcServingStatus[serving])
+	}
+	return nil
+}
+
+// grpcHealthStatus reads HealthCheckResponse.status (field 1, a varint) from a single gRPC message frame.
+func grpcHealthStatus(frame []byte) (uint64, error) {
+	if len(frame) < 5 || frame[0] != 0 || int(binary.BigEndian.Uint32(frame[1:5])) != len(frame)-5 {
+		return 0, errors.New("malformed gRPC response frame")
+	}
+	msg := frame[5:]
+	var status uint64
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("malformed health check response")
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errors.New("malformed health check response")
+			}
+			if key>>3 == 1 {
+				status = v
+			}
+			msg = msg[n:]
+		case 2: // length-delimited, skipped
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errors.New("malformed health check response")
+			}
+			msg = msg[n+int(l):]
+		case 1, 5: // fixed64, fixed32, skipped
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return 0, errors.New("malformed health check response")
+			}
+			msg = msg[size:]
+		default:
+			return 0, errors.New("malformed health check response")
+		}
+	}
+	return status, nil
+}
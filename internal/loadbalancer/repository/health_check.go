@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"GoRelay/pkg/utils"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHealthTimeout time.Duration = 5 * time.Second
+	// Bodies and replies are only read this far when matching them
+	maxHealthBody int64 = 64 * 1024
+)
+
+// Health check types. Without one the backend's scheme decides: tcp:// backends are connected to, udp:// ones aren't checked, others get HTTP.
+const (
+	CheckHTTP       = "http"
+	CheckTCP        = "tcp"
+	CheckSendExpect = "send_expect"
+	CheckGRPC       = "grpc"
+)
+
+/*
+HealthCheck is how a pool probes its backends. The HTTP fields describe the request sent and what the
+response must look like; Send and Expect are the bytes written and the start of the reply for
+send_expect; Service is the name asked about in a grpc.health.v1 check, the whole server when empty.
+*/
+type HealthCheck struct {
+	Type    string
+	Timeout time.Duration
+
+	Method  string
+	Path    *url.URL
+	Host    string
+	Headers http.Header
+
+	Send   []byte
+	Expect []byte
+
+	Service string
+
+	statuses  []statusRange
+	bodyRegex *regexp.Regexp
+	jsonPath  []string
+	jsonValue string
+}
+
+// defaultHealthCheck picks the type by scheme; HTTP backends get a HEAD request to their URL accepting any 2xx.
+func defaultHealthCheck() *HealthCheck {
+	return &HealthCheck{
+		Timeout:  DefaultHealthTimeout,
+		Method:   http.MethodHead,
+		Path:     &url.URL{},
+		statuses: []statusRange{{200, 299}},
+	}
+}
+
+func HealthCheckFromConfig(cfg utils.HealthCheckConfig) (*HealthCheck, error) {
+	check := defaultHealthCheck()
+	check.Type = cfg.Type
+	if cfg.Timeout > 0 {
+		check.Timeout = cfg.Timeout
+	}
+	if cfg.Path != "" {
+		path, err := url.Parse(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("health check path: %w", err)
+		}
+		check.Path = path
+	}
+	check.Host = cfg.Host
+	if len(cfg.Headers) > 0 {
+		check.Headers = make(http.Header, len(cfg.Headers))
+		for name, value := range cfg.Headers {
+			check.Headers.Set(name, value)
+		}
+	}
+	if len(cfg.ExpectedStatus) > 0 {
+		check.statuses = nil
+		for _, s := range cfg.ExpectedStatus {
+			r, err := parseStatusRange(s)
+			if err != nil {
+				return nil, err
+			}
+			check.statuses = append(check.statuses, r)
+		}
+	}
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("health check body regex: %w", err)
+		}
+		check.bodyRegex = re
+	}
+	if cfg.JSONPath != "" {
+		check.jsonPath = strings.Split(strings.TrimPrefix(cfg.JSONPath, "$."), ".")
+		check.jsonValue = cfg.JSONValue
+	}
+	switch {
+	case cfg.Method != "":
+		check.Method = cfg.Method
+	case check.matchesBody():
+		check.Method = http.MethodGet
+	}
+	check.Send, check.Expect = []byte(cfg.Send), []byte(cfg.Expect)
+	if check.Type == CheckSendExpect && len(check.Expect) == 0 {
+		return nil, fmt.Errorf("health check: send_expect needs expect")
+	}
+	check.Service = cfg.Service
+	return check, nil
+}
+
+// typeFor returns the check type used for a backend, "" when it isn't checked.
+func (c *HealthCheck) typeFor(backend *url.URL) string {
+	if c.Type != "" {
+		return c.Type
+	}
+	switch backend.Scheme {
+	case "tcp":
+		return CheckTCP
+	case "udp":
+		// Nothing to connect to: refused datagrams are caught passively by outlier detection
+		return ""
+	}
+	return CheckHTTP
+}
+
+// hostPort is the address to connect to for backend, with the scheme's default port when it has none.
+func hostPort(backend *url.URL) string {
+	if backend.Port() != "" {
+		return backend.Host
+	}
+	port := "80"
+	if backend.Scheme == "https" {
+		port = "443"
+	}
+	return backend.Host + ":" + port
+}
@@ -3,35 +3,68 @@ package repository
 import (
 	"GoRelay/internal/models"
 	"GoRelay/pkg/logger"
+	"context"
 	"net/http"
-	"time"
 )
 
 type HealthRepository struct {
 	client *http.Client
+	check  *HealthCheck
 	logger *logger.Logger
 }
 
-func NewHealthRepository(logger *logger.Logger) *HealthRepository {
-	var httpClient = &http.Client{
-		Timeout: 5 * time.Second,
+type HealthOption func(*HealthRepository)
+
+// WithTransport makes health checks connect like the proxy does, e.g. with the pool's upstream TLS settings.
+func WithTransport(transport http.RoundTripper) HealthOption {
+	return func(r *HealthRepository) {
+		r.client.Transport = transport
+	}
+}
+
+// WithHealthCheck replaces the default check, which picks the probe by backend scheme.
+func WithHealthCheck(check *HealthCheck) HealthOption {
+	return func(r *HealthRepository) {
+		r.check = check
 	}
-	return &HealthRepository{
+}
+
+func NewHealthRepository(logger *logger.Logger, opts ...HealthOption) *HealthRepository {
+	r := &HealthRepository{
 		logger: logger,
-		client: httpClient,
+		client: &http.Client{},
+		check:  defaultHealthCheck(),
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *HealthRepository) CheckHealth(backend *models.Backend) bool {
-	resp, err := r.client.Head(backend.URL.String())
-	if err != nil {
-		r.logger.Error("error sending HEAD request to backend", "url", backend.URL.String(), "error", err)
-		return false
+	return r.Probe(backend) == nil
+}
+
+// Probe checks backend like CheckHealth and returns why it failed, nil when it passed.
+func (r *HealthRepository) Probe(backend *models.Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.check.Timeout)
+	defer cancel()
+	var err error
+	kind := r.check.typeFor(backend.URL)
+	switch kind {
+	case "":
+		return nil
+	case CheckTCP:
+		err = r.checkTCP(ctx, backend)
+	case CheckSendExpect:
+		err = r.checkSendExpect(ctx, backend)
+	case CheckGRPC:
+		err = r.checkGRPC(ctx, backend)
+	default:
+		err = r.checkHTTP(ctx, backend)
 	}
-	defer resp.Body.Close()
-	isHealthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	if !isHealthy {
-		r.logger.Warn("backend unhealthy", "url", backend.URL.String(), "status", resp.StatusCode)
+	if err != nil {
+		r.logger.Debug("health check failed", "url", backend.URL.String(), "check", kind, "error", err)
 	}
-	return isHealthy
+	return err
 }
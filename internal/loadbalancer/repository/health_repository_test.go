@@ -3,10 +3,15 @@ package repository
 import (
 	"GoRelay/internal/models"
 	"GoRelay/pkg/logger"
+	"GoRelay/pkg/utils"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHealthRepository_CheckHealth(t *testing.T) {
@@ -59,3 +64,235 @@ func TestHealthRepository_CheckHealth(t *testing.T) {
 		})
 	}
 }
+
+func TestHealthRepository_WithTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	backend, err := models.NewBackend(server.URL)
+	assert.NoError(t, err, "Failed to create backend")
+
+	logger := logger.NewLogger()
+	assert.False(t, NewHealthRepository(logger).CheckHealth(backend), "Expected the unknown CA to be rejected")
+	assert.True(t, NewHealthRepository(logger, WithTransport(server.Client().Transport)).CheckHealth(backend), "Expected the pool's TLS settings to be used")
+}
+
+func TestHealthRepository_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	backend, err := models.NewBackend("tcp://" + addr)
+	assert.NoError(t, err, "Failed to create backend")
+	repo := NewHealthRepository(logger.NewLogger())
+
+	assert.True(t, repo.CheckHealth(backend), "Expected a listening backend to be healthy")
+	ln.Close()
+	assert.False(t, repo.CheckHealth(backend), "Expected a refused connection to be unhealthy")
+}
+
+func TestHealthRepository_HTTPCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/healthz":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodHead:
+			// Like the apps that reject HEAD
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.Host == "other":
+			w.WriteHeader(http.StatusMisdirectedRequest)
+		case r.Header.Get("X-Probe") == "deep":
+			w.Write([]byte(`{"status":"degraded","checks":[{"name":"db","ok":false}]}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	backend, err := models.NewBackend(server.URL)
+	assert.NoError(t, err, "Failed to create backend")
+
+	tests := []struct {
+		name          string
+		cfg           utils.HealthCheckConfig
+		expectHealthy bool
+	}{
+		{name: "DefaultHeadOnRoot", expectHealthy: false},
+		{name: "GetOnPath", cfg: utils.HealthCheckConfig{Path: "/healthz", Method: "GET"}, expectHealthy: true},
+		{name: "HeadRejected", cfg: utils.HealthCheckConfig{Path: "/healthz"}, expectHealthy: false},
+		{name: "HostHeader", cfg: utils.HealthCheckConfig{Path: "/healthz", Method: "GET", Host: "app.internal"}, expectHealthy: true},
+		{name: "WrongHostHeader", cfg: utils.HealthCheckConfig{Path: "/healthz", Method: "GET", Host: "other"}, expectHealthy: false},
+		{name: "StatusOutsideRanges", cfg: utils.HealthCheckConfig{Path: "/healthz", Method: "GET", ExpectedStatus: []string{"200", "3xx"}}, expectHealthy: false},
+		{name: "StatusInRange", cfg: utils.HealthCheckConfig{Path: "/healthz", Method: "GET", ExpectedStatus: []string{"200-204"}}, expectHealthy: true},
+		{name: "NotFoundExpected", cfg: utils.HealthCheckConfig{Path: "/missing", Method: "GET", ExpectedStatus: []string{"404"}}, expectHealthy: true},
+		{name: "BodyRegex", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, BodyRegex: `"status":"(ok|degraded)"`}, expectHealthy: true},
+		{name: "BodyRegexMismatch", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, BodyRegex: `"status":"ok"`}, expectHealthy: false},
+		{name: "JSONPathExists", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, JSONPath: "checks.0.name"}, expectHealthy: true},
+		{name: "JSONPathValue", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, JSONPath: "status", JSONValue: "degraded"}, expectHealthy: true},
+		{name: "JSONPathBoolean", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, JSONPath: "checks.0.ok", JSONValue: "true"}, expectHealthy: false},
+		{name: "JSONPathMissing", cfg: utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, JSONPath: "checks.1.name"}, expectHealthy: false},
+		{name: "JSONOnEmptyBody", cfg: utils.HealthCheckConfig{Path: "/healthz", JSONPath: "status"}, expectHealthy: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := HealthCheckFromConfig(tt.cfg)
+			assert.NoError(t, err)
+			repo := NewHealthRepository(logger.NewLogger(), WithHealthCheck(check))
+			assert.Equal(t, tt.expectHealthy, repo.CheckHealth(backend), "Unexpected health status")
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer slow.Close()
+		b, _ := models.NewBackend(slow.URL)
+		check, _ := HealthCheckFromConfig(utils.HealthCheckConfig{Timeout: 50 * time.Millisecond})
+		assert.False(t, NewHealthRepository(logger.NewLogger(), WithHealthCheck(check)).CheckHealth(b), "Expected a slow answer to fail the check")
+	})
+
+	t.Run("BackendBasePath", func(t *testing.T) {
+		var got string
+		app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.RequestURI()
+		}))
+		defer app.Close()
+		b, _ := models.NewBackend(app.URL + "/app?tenant=a")
+		check, _ := HealthCheckFromConfig(utils.HealthCheckConfig{Path: "/healthz?full=1"})
+		assert.True(t, NewHealthRepository(logger.NewLogger(), WithHealthCheck(check)).CheckHealth(b))
+		assert.Equal(t, "/app/healthz?tenant=a&full=1", got, "Expected the path joined onto the backend's like proxied requests")
+	})
+
+	t.Run("FailureReasons", func(t *testing.T) {
+		probe := func(cfg utils.HealthCheckConfig) error {
+			check, _ := HealthCheckFromConfig(cfg)
+			return NewHealthRepository(logger.NewLogger(), WithHealthCheck(check)).Probe(backend)
+		}
+		assert.NoError(t, probe(utils.HealthCheckConfig{Path: "/healthz", Method: "GET"}))
+		assert.EqualError(t, probe(utils.HealthCheckConfig{Path: "/healthz"}), "status 405")
+		err := probe(utils.HealthCheckConfig{Path: "/healthz", Headers: map[string]string{"X-Probe": "deep"}, BodyRegex: `"status":"ok"`})
+		if assert.Error(t, err) {
+			assert.True(t, strings.HasPrefix(err.Error(), "body mismatch"), "Unexpected reason %q", err)
+		}
+	})
+
+	t.Run("InvalidStatus", func(t *testing.T) {
+		_, err := HealthCheckFromConfig(utils.HealthCheckConfig{ExpectedStatus: []string{"299-200"}})
+		assert.Error(t, err)
+		assert.Error(t, utils.ValidateConfig(&utils.Config{
+			HealthInterval: 1,
+			Algorithm:      "round_robin",
+			Port:           "8080",
+			Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+			HealthCheck:    utils.HealthCheckConfig{Method: "HEAD", BodyRegex: "ok"},
+		}, algorithms), "Expected a body check on HEAD to be rejected")
+	})
+}
+
+func TestHealthRepository_SendExpect(t *testing.T) {
+	// A Redis lookalike answering PING, with the reply split over two writes
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				if string(buf[:n]) == "PING\r\n" {
+					conn.Write([]byte("+PO"))
+					time.Sleep(10 * time.Millisecond)
+					conn.Write([]byte("NG\r\n"))
+				} else {
+					conn.Write([]byte("-ERR unknown command\r\n"))
+				}
+			}()
+		}
+	}()
+	backend, _ := models.NewBackend("tcp://" + ln.Addr().String())
+
+	probe := func(send, expect string) bool {
+		check, err := HealthCheckFromConfig(utils.HealthCheckConfig{Type: CheckSendExpect, Send: send, Expect: expect, Timeout: time.Second})
+		assert.NoError(t, err)
+		return NewHealthRepository(logger.NewLogger(), WithHealthCheck(check)).CheckHealth(backend)
+	}
+	assert.True(t, probe("PING\r\n", "+PONG"), "Expected the reply to be matched across reads")
+	assert.False(t, probe("PING\r\n", "+PONG\r\n+OK"), "Expected a reply shorter than expected to fail")
+	assert.False(t, probe("HELLO\r\n", "+PONG"), "Expected a wrong reply to fail")
+
+	_, err = HealthCheckFromConfig(utils.HealthCheckConfig{Type: CheckSendExpect, Send: "PING\r\n"})
+	assert.Error(t, err, "Expected send_expect without expect to be rejected")
+}
+
+func TestHealthRepository_GRPC(t *testing.T) {
+	// status answers a Health/Check call for each service name
+	status := map[string]byte{"": 1, "billing": 2}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 7 {
+			service = string(body[7:])
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		s, ok := status[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, s})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	backend, _ := models.NewBackend(server.URL)
+
+	probe := func(service string) bool {
+		check, err := HealthCheckFromConfig(utils.HealthCheckConfig{Type: CheckGRPC, Service: service})
+		assert.NoError(t, err)
+		repo := NewHealthRepository(logger.NewLogger(), WithTransport(server.Client().Transport), WithHealthCheck(check))
+		return repo.CheckHealth(backend)
+	}
+	assert.True(t, probe(""), "Expected a SERVING server to pass")
+	assert.False(t, probe("billing"), "Expected a NOT_SERVING service to fail")
+	assert.False(t, probe("unknown"), "Expected an error status to fail")
+}
+
+func TestHealthCheckValidation(t *testing.T) {
+	cfg := &utils.Config{
+		HealthInterval: 1,
+		Algorithm:      "round_robin",
+		Port:           "8080",
+		Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+		Pools: map[string]utils.PoolConfig{
+			"redis": {Backends: []utils.BackendConfig{{URL: "tcp://10.0.0.1:6379"}}, HealthCheck: utils.HealthCheckConfig{Type: "send_expect", Send: "PING\r\n", Expect: "+PONG"}},
+			"grpc":  {Backends: []utils.BackendConfig{{URL: "http://10.0.0.2:50051"}}, Protocol: "h2c", HealthCheck: utils.HealthCheckConfig{Type: "grpc"}},
+		},
+	}
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms))
+
+	cfg.Pools["redis"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "tcp://10.0.0.1:6379"}}, HealthCheck: utils.HealthCheckConfig{Type: "send_expect"}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected send_expect without expect to be rejected")
+	cfg.Pools["redis"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "tcp://10.0.0.1:6379"}}, HealthCheck: utils.HealthCheckConfig{Type: "http"}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected an HTTP check on a TCP pool to be rejected")
+	delete(cfg.Pools, "redis")
+	cfg.Pools["grpc"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "http://10.0.0.2:50051"}}, Protocol: "http1", HealthCheck: utils.HealthCheckConfig{Type: "grpc"}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected a gRPC check over HTTP/1 to be rejected")
+	cfg.Pools["grpc"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "http://10.0.0.2:50051"}}, HealthCheck: utils.HealthCheckConfig{Type: "grpc"}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected a gRPC check on cleartext backends without h2c to be rejected")
+	cfg.Pools["grpc"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "https://10.0.0.2:50051"}}, HealthCheck: utils.HealthCheckConfig{Type: "grpc"}}
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected HTTP/2 to be negotiated over TLS")
+	cfg.Pools["grpc"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "https://10.0.0.2:50051"}}, HealthCheck: utils.HealthCheckConfig{Flap: utils.FlapDetectionConfig{Transitions: 4}}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected flap detection without a window to be rejected")
+}
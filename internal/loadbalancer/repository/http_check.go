@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"GoRelay/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type statusRange struct{ min, max int }
+
+// parseStatusRange accepts "200", "200-399" and "2xx".
+func parseStatusRange(s string) (statusRange, error) {
+	if class, ok := strings.CutSuffix(s, "xx"); ok {
+		n, err := strconv.Atoi(class)
+		if err != nil || n < 1 || n > 5 {
+			return statusRange{}, fmt.Errorf("health check status %q: unknown class", s)
+		}
+		return statusRange{n * 100, n*100 + 99}, nil
+	}
+	low, high, isRange := strings.Cut(s, "-")
+	if !isRange {
+		high = low
+	}
+	lo, err1 := strconv.Atoi(low)
+	hi, err2 := strconv.Atoi(high)
+	if err1 != nil || err2 != nil || lo < 100 || hi > 599 || lo > hi {
+		return statusRange{}, fmt.Errorf("health check status %q: expected a code, a range or a class", s)
+	}
+	return statusRange{lo, hi}, nil
+}
+
+// checkHTTP sends the check request to checkURL and verifies the response.
+func (r *HealthRepository) checkHTTP(ctx context.Context, backend *models.Backend) error {
+	c := r.check
+	req, err := http.NewRequestWithContext(ctx, c.Method, c.checkURL(backend.URL).String(), nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.Headers {
+		req.Header[name] = values
+	}
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.verify(resp)
+}
+
+// checkURL joins Path onto the backend's path and query the way proxied requests are, so http://host/app is checked at /app/healthz.
+func (c *HealthCheck) checkURL(base *url.URL) *url.URL {
+	u := *base
+	if c.Path.Path != "" {
+		u.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(c.Path.Path, "/")
+		u.RawPath = ""
+	}
+	switch {
+	case c.Path.RawQuery == "":
+	case u.RawQuery == "":
+		u.RawQuery = c.Path.RawQuery
+	default:
+		u.RawQuery += "&" + c.Path.RawQuery
+	}
+	return &u
+}
+
+func (c *HealthCheck) matchesBody() bool {
+	return c.bodyRegex != nil || c.jsonPath != nil
+}
+
+// verify returns why resp fails the check, nil when it passes.
+func (c *HealthCheck) verify(resp *http.Response) error {
+	if !c.statusExpected(resp.StatusCode) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if !c.matchesBody() {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return fmt.Errorf("body mismatch: %q not found", c.bodyRegex)
+	}
+	if c.jsonPath != nil {
+		return c.verifyJSON(body)
+	}
+	return nil
+}
+
+func (c *HealthCheck) statusExpected(code int) bool {
+	for _, r := range c.statuses {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyJSON walks jsonPath through objects and arrays and compares the leaf with jsonValue.
+func (c *HealthCheck) verifyJSON(body []byte) error {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("body mismatch: not JSON: %w", err)
+	}
+	path := strings.Join(c.jsonPath, ".")
+	for _, key := range c.jsonPath {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[key]
+			if !ok {
+				return fmt.Errorf("body mismatch: JSON path %q not found", path)
+			}
+			doc = value
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return fmt.Errorf("body mismatch: JSON path %q not found", path)
+			}
+			doc = node[i]
+		default:
+			return fmt.Errorf("body mismatch: JSON path %q not found", path)
+		}
+	}
+	if c.jsonValue == "" {
+		return nil
+	}
+	// Strings compare as they are, numbers and booleans in their JSON spelling
+	actual, ok := doc.(string)
+	if !ok {
+		raw, _ := json.Marshal(doc)
+		actual = string(raw)
+	}
+	if actual != c.jsonValue {
+		return fmt.Errorf("body mismatch: JSON path %q is %q, expected %q", path, actual, c.jsonValue)
+	}
+	return nil
+}
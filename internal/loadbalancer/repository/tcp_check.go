@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"GoRelay/internal/models"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+)
+
+// checkTCP passes when the backend accepts a connection.
+func (r *HealthRepository) checkTCP(ctx context.Context, backend *models.Backend) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort(backend.URL))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+/*
+checkSendExpect writes Send (nothing when empty, for protocols where the server speaks first) and
+passes when the reply starts with Expect, e.g. "PING\r\n" and "+PONG" for Redis.
+*/
+func (r *HealthRepository) checkSendExpect(ctx context.Context, backend *models.Backend) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort(backend.URL))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if len(r.check.Send) > 0 {
+		if _, err := conn.Write(r.check.Send); err != nil {
+			return err
+		}
+	}
+	// The reply may come in pieces, read until there is enough of it to compare
+	reply := make([]byte, 0, len(r.check.Expect))
+	buf := make([]byte, len(r.check.Expect))
+	for len(reply) < len(r.check.Expect) {
+		n, err := conn.Read(buf[:len(r.check.Expect)-len(reply)])
+		reply = append(reply, buf[:n]...)
+		if !bytes.HasPrefix(r.check.Expect, reply) {
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(reply, r.check.Expect) {
+		return fmt.Errorf("reply mismatch: %q does not start with %q", reply, r.check.Expect)
+	}
+	return nil
+}
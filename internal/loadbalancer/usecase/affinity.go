@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultAffinityCookie string = "gorelay_affinity"
+
+/*
+AffinityPolicy pins clients to the backend that served their first request through a cookie.
+The cookie holds an opaque backend ID (derived from its URL, never the URL itself) and an HMAC over it,
+so clients can neither read nor forge which backend they are pinned to.
+*/
+type AffinityPolicy struct {
+	CookieName string
+	TTL        time.Duration
+	Path       string
+	Domain     string
+	Secure     bool
+	HTTPOnly   bool
+	SameSite   http.SameSite
+	secret     []byte
+
+	mux     sync.Mutex
+	members []*models.Backend          // the pool membership ids was built for
+	ids     map[string]*models.Backend // backend ID -> member
+}
+
+// AffinityPolicyFromConfig returns nil when affinity is disabled. Without a configured secret a random one is
+// generated, which invalidates every issued cookie on restart.
+func AffinityPolicyFromConfig(cfg utils.AffinityConfig) (*AffinityPolicy, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	policy := &AffinityPolicy{
+		CookieName: cfg.CookieName,
+		TTL:        cfg.TTL,
+		Path:       cfg.Path,
+		Domain:     cfg.Domain,
+		Secure:     cfg.Secure,
+		HTTPOnly:   cfg.HTTPOnly == nil || *cfg.HTTPOnly,
+		secret:     []byte(cfg.Secret),
+	}
+	if policy.CookieName == "" {
+		policy.CookieName = DefaultAffinityCookie
+	}
+	if policy.Path == "" {
+		policy.Path = "/"
+	}
+	switch strings.ToLower(cfg.SameSite) {
+	case "strict":
+		policy.SameSite = http.SameSiteStrictMode
+	case "none":
+		policy.SameSite = http.SameSiteNoneMode
+	case "lax":
+		policy.SameSite = http.SameSiteLaxMode
+	}
+	if len(policy.secret) == 0 {
+		policy.secret = make([]byte, 32)
+		if _, err := rand.Read(policy.secret); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+/*
+ForPool returns the policy for the named pool. Every pool needs its own cookie: with a shared one a
+client using two pools would be re-pinned, and the cookie rewritten, on every request crossing them.
+The default pool keeps the configured name, others get "<name>_<pool>".
+*/
+func (p *AffinityPolicy) ForPool(pool string) *AffinityPolicy {
+	if p == nil {
+		return nil
+	}
+	name := p.CookieName
+	if pool != utils.DefaultPool {
+		name += "_" + strings.Map(func(r rune) rune {
+			if r < 0x80 && (r == '-' || r == '.' || r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return r
+			}
+			return '_' // not allowed in a cookie name
+		}, pool)
+	}
+	return &AffinityPolicy{
+		CookieName: name,
+		TTL:        p.TTL,
+		Path:       p.Path,
+		Domain:     p.Domain,
+		Secure:     p.Secure,
+		HTTPOnly:   p.HTTPOnly,
+		SameSite:   p.SameSite,
+		secret:     p.secret,
+	}
+}
+
+func WithAffinity(policy *AffinityPolicy) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.affinity = policy
+	}
+}
+
+func (p *AffinityPolicy) backendID(b *models.Backend) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("backend:" + b.URL.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// lookup returns the member of pool with the given ID. The IDs are rebuilt when the membership changes, so backends that left are forgotten.
+func (p *AffinityPolicy) lookup(pool *models.ServerPool, id string) *models.Backend {
+	members := pool.AllBackends()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.ids == nil || !slices.Equal(p.members, members) {
+		p.members = slices.Clone(members)
+		p.ids = make(map[string]*models.Backend, len(members))
+		for _, b := range members {
+			if id := p.backendID(b); p.ids[id] == nil {
+				p.ids[id] = b
+			}
+		}
+	}
+	return p.ids[id]
+}
+
+func (p *AffinityPolicy) sign(id string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte("cookie:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// cookie builds the affinity cookie naming b.
+func (p *AffinityPolicy) cookie(b *models.Backend) *http.Cookie {
+	id := p.backendID(b)
+	c := &http.Cookie{
+		Name:     p.CookieName,
+		Value:    id + "." + p.sign(id),
+		Path:     p.Path,
+		Domain:   p.Domain,
+		Secure:   p.Secure,
+		HttpOnly: p.HTTPOnly,
+		SameSite: p.SameSite,
+	}
+	if p.TTL > 0 {
+		c.MaxAge = int(p.TTL.Seconds())
+	}
+	return c
+}
+
+// pinned returns the available backend named by a valid affinity cookie on req, or nil.
+func (p *AffinityPolicy) pinned(req *http.Request, pool *models.ServerPool) *models.Backend {
+	c, err := req.Cookie(p.CookieName)
+	if err != nil {
+		return nil
+	}
+	id, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(p.sign(id))) {
+		return nil
+	}
+	if b := p.lookup(pool, id); b != nil && b.IsAvailable() {
+		return b
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionAffinity(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	s1, s2 := upstream("one"), upstream("two")
+	defer s1.Close()
+	defer s2.Close()
+
+	newUseCase := func(t *testing.T) (*LoadBalancerUseCase, []*models.Backend) {
+		policy, err := AffinityPolicyFromConfig(utils.AffinityConfig{Enabled: true, Secret: "s3cret", TTL: time.Hour, SameSite: "lax"})
+		assert.NoError(t, err, "Expected no error")
+		b1, _ := models.NewBackend(s1.URL)
+		b2, _ := models.NewBackend(s2.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(b1)
+		pool.AddBackend(b2)
+		return NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithAffinity(policy)), []*models.Backend{b1, b2}
+	}
+	send := func(uc *LoadBalancerUseCase, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		uc.HandleRequest(req, w)
+		return w
+	}
+	affinityCookie := func(w *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == DefaultAffinityCookie {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("FirstResponseSetsCookie", func(t *testing.T) {
+		uc, _ := newUseCase(t)
+		w := send(uc, nil)
+		c := affinityCookie(w)
+		assert.NotNil(t, c, "Expected an affinity cookie")
+		assert.NotContains(t, c.Value, "127.0.0.1", "Cookie should not reveal the backend address")
+		assert.Equal(t, 3600, c.MaxAge, "Cookie should carry the configured TTL")
+		assert.True(t, c.HttpOnly, "Cookie should be HttpOnly by default")
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite, "Cookie should carry the configured SameSite")
+	})
+
+	t.Run("CookiePinsBackend", func(t *testing.T) {
+		uc, _ := newUseCase(t)
+		first := send(uc, nil)
+		c := affinityCookie(first)
+		for range 5 {
+			w := send(uc, c)
+			assert.Equal(t, first.Body.String(), w.Body.String(), "Pinned requests should reach the same backend")
+			assert.Nil(t, affinityCookie(w), "A valid cookie should not be rewritten")
+		}
+	})
+
+	t.Run("TamperedCookieIsIgnored", func(t *testing.T) {
+		uc, _ := newUseCase(t)
+		c := affinityCookie(send(uc, nil))
+		id, _, _ := strings.Cut(c.Value, ".")
+		forged := &http.Cookie{Name: c.Name, Value: id + ".forged"}
+
+		w := send(uc, forged)
+		assert.NotNil(t, affinityCookie(w), "A forged cookie should be replaced")
+	})
+
+	t.Run("EjectedBackendFallsBack", func(t *testing.T) {
+		uc, backends := newUseCase(t)
+		first := send(uc, nil)
+		c := affinityCookie(first)
+		pinned := backends[0]
+		if first.Body.String() == "two" {
+			pinned = backends[1]
+		}
+		pinned.Eject(time.Now().Add(time.Minute))
+
+		w := send(uc, c)
+		assert.NotEqual(t, first.Body.String(), w.Body.String(), "Request should move off the ejected backend")
+		rewritten := affinityCookie(w)
+		assert.NotNil(t, rewritten, "Cookie should be rewritten for the new backend")
+		assert.NotEqual(t, c.Value, rewritten.Value, "Cookie should name the new backend")
+
+		again := send(uc, rewritten)
+		assert.Equal(t, w.Body.String(), again.Body.String(), "Rewritten cookie should pin the new backend")
+	})
+
+	t.Run("RemovedBackendIsForgotten", func(t *testing.T) {
+		uc, backends := newUseCase(t)
+		first := send(uc, nil)
+		c := affinityCookie(first)
+		pinned := backends[0]
+		if first.Body.String() == "two" {
+			pinned = backends[1]
+		}
+		uc.Pool.RemoveBackend(pinned)
+
+		w := send(uc, c)
+		assert.NotEqual(t, first.Body.String(), w.Body.String(), "Request should move off the removed backend")
+		assert.NotNil(t, affinityCookie(w), "Cookie should be rewritten for the remaining backend")
+		assert.Len(t, uc.affinity.ids, 1, "The removed backend's ID should be dropped")
+		assert.NotContains(t, uc.affinity.ids, uc.affinity.backendID(pinned), "The removed backend's ID should be dropped")
+	})
+
+	t.Run("PoolsKeepTheirOwnCookie", func(t *testing.T) {
+		policy, err := AffinityPolicyFromConfig(utils.AffinityConfig{Enabled: true, Secret: "s3cret"})
+		assert.NoError(t, err, "Expected no error")
+		newPool := func(name string, servers ...*httptest.Server) *LoadBalancerUseCase {
+			pool := models.NewServerPool()
+			for _, s := range servers {
+				b, _ := models.NewBackend(s.URL)
+				pool.AddBackend(b)
+			}
+			return NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithAffinity(policy.ForPool(name)))
+		}
+		web, api := newPool(utils.DefaultPool, s1, s2), newPool("api", s2, s1)
+		assert.Equal(t, DefaultAffinityCookie+"_api", api.affinity.CookieName)
+
+		// The client sends back every cookie it got, as a browser would
+		jar := map[string]*http.Cookie{}
+		request := func(uc *LoadBalancerUseCase) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/", nil)
+			for _, c := range jar {
+				req.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			uc.HandleRequest(req, w)
+			for _, c := range w.Result().Cookies() {
+				jar[c.Name] = c
+			}
+			return w
+		}
+		first := request(web).Body.String()
+		request(api)
+		for range 3 {
+			w := request(web)
+			assert.Equal(t, first, w.Body.String(), "Expected the client to stay pinned across pools")
+			assert.Empty(t, w.Result().Cookies(), "Expected no cookie rewrite")
+			assert.Empty(t, request(api).Result().Cookies(), "Expected no cookie rewrite")
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/utils"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+/*
+Balancer is a backend selection strategy. Select is called for every attempt and must be safe for
+concurrent use; it returns nil when no backend is available. Strategies should skip backends that are
+not IsAvailable() and may honour rc.Exclude (HandleRequest copes with strategies that don't).
+*/
+type Balancer interface {
+	Select(pool *models.ServerPool, rc *RequestContext) *models.Backend
+}
+
+// RequestContext is what a Balancer gets to see about the request being routed.
+type RequestContext struct {
+	Request    *http.Request // nil outside HTTP, e.g. a bare SelectBackend call
+	Header     http.Header
+	ClientAddr string                   // real client address, see clientip.FromRequest
+	Exclude    map[*models.Backend]bool // backends already tried for this request
+}
+
+func newRequestContext(req *http.Request, exclude map[*models.Backend]bool) *RequestContext {
+	rc := &RequestContext{Exclude: exclude}
+	if req != nil {
+		rc.Request = req
+		rc.Header = req.Header
+		if addr := clientip.FromRequest(req); addr.IsValid() {
+			rc.ClientAddr = addr.String()
+		} else {
+			rc.ClientAddr = req.RemoteAddr
+		}
+	}
+	return rc
+}
+
+// BalancerOptions carries the strategy specific settings from the config to a BalancerFactory.
+type BalancerOptions struct {
+	Hash HashPolicy
+}
+
+type BalancerFactory func(opts BalancerOptions) Balancer
+
+var (
+	balancersMux sync.RWMutex
+	balancers    = map[string]BalancerFactory{}
+)
+
+/*
+RegisterBalancer makes a strategy available under name (and aliases) to NewBalancer and to config
+validation. Names are matched case-insensitively ignoring '_' and '-', so round_robin, roundRobin and
+roundrobin are the same. Call it from an init function before the config is loaded.
+*/
+func RegisterBalancer(name string, factory BalancerFactory, aliases ...string) {
+	balancersMux.Lock()
+	defer balancersMux.Unlock()
+	for _, n := range append([]string{name}, aliases...) {
+		key := utils.NormalizeAlgorithm(n)
+		if _, dup := balancers[key]; dup {
+			panic(fmt.Sprintf("usecase: balancer %q registered twice", n))
+		}
+		balancers[key] = factory
+	}
+}
+
+func NewBalancer(name string, opts BalancerOptions) (Balancer, error) {
+	balancersMux.RLock()
+	factory, ok := balancers[utils.NormalizeAlgorithm(name)]
+	balancersMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown balancing algorithm %q", name)
+	}
+	return factory(opts), nil
+}
+
+// BalancerNames lists every registered name and alias, normalized.
+func BalancerNames() []string {
+	balancersMux.RLock()
+	defer balancersMux.RUnlock()
+	names := make([]string, 0, len(balancers))
+	for name := range balancers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// WithBalancer overrides the strategy picked by name in NewLoadBalancerUseCase.
+func WithBalancer(b Balancer) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.balancer = b
+	}
+}
+
+func init() {
+	RegisterBalancer(RoundRobin, func(BalancerOptions) Balancer { return &roundRobinBalancer{} })
+	RegisterBalancer(LeastConnections, func(BalancerOptions) Balancer { return leastConnBalancer{} }, "least_conn")
+	RegisterBalancer(ConsistentHash, func(opts BalancerOptions) Balancer { return &hashBalancer{policy: opts.Hash} }, "ring_hash", "consistent_hash")
+	RegisterBalancer(PowerOfTwo, func(BalancerOptions) Balancer { return p2cBalancer{} }, "power_of_two")
+	RegisterBalancer(PeakEWMA, func(BalancerOptions) Balancer { return peakEWMABalancer{} })
+}
+
+/*
+roundRobinBalancer implements nginx's smooth weighted round robin: every pick each healthy backend
+gains its weight, the one with the highest running total wins and pays back the sum of all weights.
+With weights 5,1,1 the picks go A A B A C A A rather than A A A A A B C; equal weights give plain round robin.
+The running totals of backends that left the pool are dropped on the next pick.
+*/
+type roundRobinBalancer struct {
+	mux     sync.Mutex
+	weights map[*models.Backend]int
+	members []*models.Backend
+}
+
+func (rr *roundRobinBalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	healthyBackends := pool.GetBackends()
+	if len(healthyBackends) == 0 {
+		return nil
+	}
+	rr.mux.Lock()
+	defer rr.mux.Unlock()
+	if rr.weights == nil {
+		rr.weights = make(map[*models.Backend]int)
+	}
+	if members := pool.AllBackends(); !slices.Equal(rr.members, members) {
+		for b := range rr.weights {
+			if !slices.Contains(members, b) {
+				delete(rr.weights, b)
+			}
+		}
+		rr.members = slices.Clone(members)
+	}
+	total := 0
+	var chosen *models.Backend
+	for _, b := range healthyBackends {
+		weight := b.GetWeight()
+		rr.weights[b] += weight
+		total += weight
+		if chosen == nil || rr.weights[b] > rr.weights[chosen] {
+			chosen = b
+		}
+	}
+	rr.weights[chosen] -= total
+	return chosen
+}
+
+// leastConnBalancer picks the backend with the fewest active connections per unit of weight.
+type leastConnBalancer struct{}
+
+func (leastConnBalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	var chosen *models.Backend
+	var chosenConns, chosenWeight int
+	for _, b := range pool.AllBackends() {
+		if !b.IsAvailable() {
+			continue
+		}
+		conns, weight := b.GetActiveConnections(), b.GetWeight()
+		// conns/weight < chosenConns/chosenWeight, without the division
+		if chosen == nil || conns*chosenWeight < chosenConns*weight {
+			chosen, chosenConns, chosenWeight = b, conns, weight
+		}
+	}
+	return chosen
+}
@@ -0,0 +1,78 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// headerBalancer routes on an X-Backend index header, to check strategies see the request.
+type headerBalancer struct{}
+
+func (headerBalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	if rc.Header.Get("X-Backend") == "second" {
+		return pool.Backends[1]
+	}
+	return pool.Backends[0]
+}
+
+func TestBalancerRegistry(t *testing.T) {
+	RegisterBalancer("test_header", func(BalancerOptions) Balancer { return headerBalancer{} })
+
+	t.Run("NamesAreNormalized", func(t *testing.T) {
+		for _, name := range []string{"round_robin", "roundRobin", "ROUNDROBIN", "least_conn", "leastconn", "LeastConnections", "peak-ewma", "test_header"} {
+			_, err := NewBalancer(name, BalancerOptions{})
+			assert.NoError(t, err, "Expected %q to resolve to a balancer", name)
+		}
+		_, err := NewBalancer("nope", BalancerOptions{})
+		assert.Error(t, err, "Expected unknown name to fail")
+		assert.Contains(t, BalancerNames(), "testheader", "Registered names should be listed")
+	})
+
+	t.Run("ConfigValidationFollowsRegistry", func(t *testing.T) {
+		cfg := &utils.Config{
+			Port:           "8080",
+			Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+			HealthInterval: 1,
+		}
+		for _, name := range []string{"round_robin", "least_conn", "hash", "p2c", "peak_ewma", "test-header"} {
+			cfg.Algorithm = name
+			assert.NoError(t, utils.ValidateConfig(cfg, BalancerNames()), "Expected %q to be a valid algorithm", name)
+		}
+		cfg.Algorithm = "nope"
+		assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Expected unknown algorithm to be rejected")
+	})
+
+	t.Run("ConfigSpellingReachesStrategy", func(t *testing.T) {
+		// least_conn used to fall through to round robin because it did not match "leastconnections"
+		pool := models.NewServerPool()
+		busy, _ := models.NewBackend("http://localhost:5001")
+		idle, _ := models.NewBackend("http://localhost:5002")
+		busy.IncrementConnections()
+		pool.AddBackend(busy)
+		pool.AddBackend(idle)
+		uc := NewLoadBalancerUseCase(pool, "least_conn", &mock.HealthRepositoryMock{}, &http.Transport{})
+		for range 3 {
+			assert.Same(t, idle, uc.SelectBackend(), "least_conn should select by connections")
+		}
+	})
+
+	t.Run("CustomStrategySeesRequest", func(t *testing.T) {
+		pool := models.NewServerPool()
+		b1, _ := models.NewBackend("http://localhost:5001")
+		b2, _ := models.NewBackend("http://localhost:5002")
+		pool.AddBackend(b1)
+		pool.AddBackend(b2)
+		uc := NewLoadBalancerUseCase(pool, "test_header", &mock.HealthRepositoryMock{}, &http.Transport{})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Backend", "second")
+		assert.Same(t, b2, uc.SelectBackendForRequest(req), "Strategy should route on the request header")
+		assert.Same(t, b1, uc.SelectBackend(), "Strategy should get an empty context outside a request")
+	})
+}
@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+/*
+replayableBody keeps a copy of the request payload so every attempt sends the same bytes.
+The ReverseProxy consumes req.Body on the first attempt, without this a retried POST/PUT goes out empty.
+*/
+type replayableBody struct {
+	data []byte
+}
+
+func (b *replayableBody) rewind(req *http.Request) {
+	req.Body = io.NopCloser(bytes.NewReader(b.data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b.data)), nil
+	}
+	req.ContentLength = int64(len(b.data))
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	// Same convention net/http uses to decide whether a request can be replayed
+	_, hasKey := req.Header["Idempotency-Key"]
+	_, hasXKey := req.Header["X-Idempotency-Key"]
+	return hasKey || hasXKey
+}
+
+/*
+prepareReplay decides whether req may be sent more than once under policy.
+When it can and the request carries a payload, the body is buffered and returned for rewinding.
+Bodies of unknown length (chunked/streaming) or above policy.MaxBodyBytes are left untouched and only get one attempt.
+*/
+func prepareReplay(req *http.Request, policy RetryPolicy) (*replayableBody, bool, error) {
+	if !isIdempotent(req) && !policy.AllowNonIdempotent {
+		return nil, false, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength < 0 || req.ContentLength > policy.MaxBodyBytes {
+		return nil, false, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	req.Body.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("reading request body: %w", err)
+	}
+	if int64(len(data)) != req.ContentLength {
+		return nil, false, fmt.Errorf("reading request body: got %d of %d bytes", len(data), req.ContentLength)
+	}
+	return &replayableBody{data: data}, true, nil
+}
@@ -0,0 +1,92 @@
+package usecase
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareReplay(t *testing.T) {
+	policy := RetryPolicy{MaxBodyBytes: 8}
+
+	tests := []struct {
+		name       string
+		req        func() *http.Request
+		policy     RetryPolicy
+		replayable bool
+		buffered   bool
+	}{
+		{
+			name:       "GetWithoutBody",
+			req:        func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			policy:     policy,
+			replayable: true,
+		},
+		{
+			name:       "PutWithSmallBody",
+			req:        func() *http.Request { return httptest.NewRequest("PUT", "/", strings.NewReader("payload")) },
+			policy:     policy,
+			replayable: true,
+			buffered:   true,
+		},
+		{
+			name:   "PutBodyTooLarge",
+			req:    func() *http.Request { return httptest.NewRequest("PUT", "/", strings.NewReader("payload too large")) },
+			policy: policy,
+		},
+		{
+			name: "PutStreamingBody",
+			req: func() *http.Request {
+				req := httptest.NewRequest("PUT", "/", strings.NewReader("chunk"))
+				req.ContentLength = -1
+				return req
+			},
+			policy: policy,
+		},
+		{
+			name:   "PostNotAllowed",
+			req:    func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader("payload")) },
+			policy: policy,
+		},
+		{
+			name:       "PostAllowed",
+			req:        func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader("payload")) },
+			policy:     RetryPolicy{MaxBodyBytes: 8, AllowNonIdempotent: true},
+			replayable: true,
+			buffered:   true,
+		},
+		{
+			name: "PostWithIdempotencyKey",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "/", strings.NewReader("payload"))
+				req.Header.Set("Idempotency-Key", "abc")
+				return req
+			},
+			policy:     policy,
+			replayable: true,
+			buffered:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req()
+			body, replayable, err := prepareReplay(req, tt.policy)
+
+			assert.NoError(t, err, "Expected no error")
+			assert.Equal(t, tt.replayable, replayable, "Unexpected replayable decision")
+			assert.Equal(t, tt.buffered, body != nil, "Unexpected buffering decision")
+			if body != nil {
+				for range 2 {
+					body.rewind(req)
+					data, _ := io.ReadAll(req.Body)
+					assert.Equal(t, "payload", string(data), "Rewound body should match the original")
+				}
+			}
+		})
+	}
+}
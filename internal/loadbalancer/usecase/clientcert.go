@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/metrics"
+	"GoRelay/pkg/utils"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const HeaderForwardedClientCert = "X-Forwarded-Client-Cert"
+
+var deniedClientCerts = metrics.Default.NewCounterVec("gorelay_client_cert_denied_total",
+	"Requests refused because the route's client certificate allowlist did not match.", "route")
+
+// ClientCertPolicy is a route's allowlist of client certificate subjects and SANs.
+type ClientCertPolicy struct {
+	Subjects []string
+	SANs     []string
+}
+
+// ClientCertPolicyFromConfig returns nil when the route accepts any client.
+func ClientCertPolicyFromConfig(cfg *utils.ClientCertConfig) (*ClientCertPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, cfg.Subjects...), cfg.SANs...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("client certificate pattern %q: %w", pattern, err)
+		}
+	}
+	return &ClientCertPolicy{Subjects: cfg.Subjects, SANs: cfg.SANs}, nil
+}
+
+/*
+Allows reports whether the connection carries a client certificate that matches. Listeners refuse
+certificates that fail verification during the handshake, so any certificate seen here is verified.
+*/
+func (p *ClientCertPolicy) Allows(state *tls.ConnectionState) bool {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	cert := state.PeerCertificates[0]
+	subject := cert.Subject.String()
+	for _, pattern := range p.Subjects {
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	for _, san := range certSANs(cert) {
+		for _, pattern := range p.SANs {
+			if ok, _ := path.Match(pattern, san); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorize answers 403 for requests the route's allowlist rejects.
+func (r *Route) authorize(req *http.Request, w http.ResponseWriter) error {
+	if r.ClientCert == nil || r.ClientCert.Allows(req.TLS) {
+		return nil
+	}
+	deniedClientCerts.With(r.Name).Inc()
+	w.WriteHeader(http.StatusForbidden)
+	return http_errors.ErrClientCertDenied
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+/*
+setClientCertHeader forwards the client's certificate identity in the Envoy format:
+Hash=<sha256 of the DER>;Subject="...";URI=...;DNS=... A chain received from a trusted proxy is kept
+in front of our element, from anybody else the header is dropped so it can't be forged.
+*/
+func setClientCertHeader(in, out *http.Request, trusted bool) {
+	prior := strings.Join(in.Header.Values(HeaderForwardedClientCert), ",")
+	out.Header.Del(HeaderForwardedClientCert)
+	if !trusted {
+		prior = ""
+	}
+	element := ""
+	if in.TLS != nil && len(in.TLS.PeerCertificates) > 0 {
+		element = xfccElement(in.TLS.PeerCertificates[0])
+	}
+	switch {
+	case prior != "" && element != "":
+		out.Header.Set(HeaderForwardedClientCert, prior+","+element)
+	case prior != "":
+		out.Header.Set(HeaderForwardedClientCert, prior)
+	case element != "":
+		out.Header.Set(HeaderForwardedClientCert, element)
+	}
+}
+
+func xfccElement(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := []string{
+		"Hash=" + hex.EncodeToString(sum[:]),
+		`Subject="` + strings.ReplaceAll(cert.Subject.String(), `"`, `\"`) + `"`,
+	}
+	for _, uri := range cert.URIs {
+		parts = append(parts, "URI="+xfccQuote(uri.String()))
+	}
+	for _, dns := range cert.DNSNames {
+		parts = append(parts, "DNS="+xfccQuote(dns))
+	}
+	return strings.Join(parts, ";")
+}
+
+// xfccQuote quotes values containing the separators of the header.
+func xfccQuote(v string) string {
+	if !strings.ContainsAny(v, `,;="`) {
+		return v
+	}
+	return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+}
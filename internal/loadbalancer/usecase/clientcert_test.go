@@ -0,0 +1,122 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newClientCert(t *testing.T, cn string, uri string) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if uri != "" {
+		u, _ := url.Parse(uri)
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestClientCertRoutes(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	var xfcc string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xfcc = r.Header.Get(HeaderForwardedClientCert)
+	}))
+	defer upstream.Close()
+	b, _ := models.NewBackend(upstream.URL)
+	pool := models.NewServerPool()
+	pool.AddBackend(b)
+	resolver, _ := clientip.NewResolver([]string{"10.0.0.0/8"})
+	uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithTrustedProxies(resolver))
+
+	routes, err := RoutesFromConfig([]utils.RouteConfig{
+		{Name: "billing", PathPrefix: "/billing", ClientCert: &utils.ClientCertConfig{Subjects: []string{"CN=billing-*,O=Acme"}}},
+		{Name: "mesh", PathPrefix: "/mesh", ClientCert: &utils.ClientCertConfig{SANs: []string{"spiffe://acme/ns/*/sa/orders"}}},
+	})
+	assert.NoError(t, err, "Expected valid routes")
+	router, err := NewRouter(map[string]*LoadBalancerUseCase{utils.DefaultPool: uc}, routes)
+	assert.NoError(t, err, "Expected valid router")
+
+	billing := newClientCert(t, "billing-worker", "")
+	orders := newClientCert(t, "orders", "spiffe://acme/ns/prod/sa/orders")
+
+	tests := []struct {
+		name     string
+		path     string
+		cert     *x509.Certificate
+		expected int
+	}{
+		{name: "SubjectMatches", path: "/billing/invoices", cert: billing, expected: http.StatusOK},
+		{name: "SubjectDoesNotMatch", path: "/billing/invoices", cert: orders, expected: http.StatusForbidden},
+		{name: "NoCertificate", path: "/billing/invoices", expected: http.StatusForbidden},
+		{name: "SANMatches", path: "/mesh/orders", cert: orders, expected: http.StatusOK},
+		{name: "SANDoesNotMatch", path: "/mesh/orders", cert: billing, expected: http.StatusForbidden},
+		{name: "RouteWithoutAllowlist", path: "/public", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			w := httptest.NewRecorder()
+			err := router.HandleRequest(req, w)
+
+			assert.Equal(t, tt.expected, w.Code, "Unexpected status")
+			if tt.expected == http.StatusForbidden {
+				assert.ErrorIs(t, err, http_errors.ErrClientCertDenied, "Expected the denial to be reported")
+			}
+		})
+	}
+
+	t.Run("ForwardedClientCert", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/mesh/orders", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{orders}}
+		req.Header.Set(HeaderForwardedClientCert, "Hash=forged")
+		router.HandleRequest(req, httptest.NewRecorder())
+
+		assert.NotContains(t, xfcc, "forged", "Expected an untrusted client's header to be dropped")
+		assert.True(t, strings.HasPrefix(xfcc, "Hash="), "Expected the certificate hash first")
+		assert.Contains(t, xfcc, `Subject="CN=orders,O=Acme"`, "Expected the quoted subject")
+		assert.Contains(t, xfcc, "URI=spiffe://acme/ns/prod/sa/orders", "Expected the URI SAN")
+	})
+
+	t.Run("TrustedProxyChainIsKept", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/public", nil)
+		req.RemoteAddr = "10.0.0.5:4000"
+		req.Header.Set(HeaderForwardedClientCert, `Hash=abc;Subject="CN=edge"`)
+		router.HandleRequest(req, httptest.NewRecorder())
+
+		assert.Equal(t, `Hash=abc;Subject="CN=edge"`, xfcc, "Expected the proxy's element to be forwarded")
+	})
+}
@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"GoRelay/pkg/clientip"
+	"net/http"
+	"strings"
+)
+
+/*
+WithTrustedProxies sets who may hand us forwarding headers. Requests from a trusted proxy have their
+X-Forwarded-For/Forwarded chain extended; from anyone else the headers are replaced, so a client
+cannot forge its address towards the backends. Without it no proxy is trusted.
+*/
+func WithTrustedProxies(resolver *clientip.Resolver) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.trustedProxies = resolver
+	}
+}
+
+// setForwardedHeaders writes X-Forwarded-For/-Proto/-Host and the RFC 7239 Forwarded header to out.
+func (uc *LoadBalancerUseCase) setForwardedHeaders(in, out *http.Request) {
+	peer := clientip.ParseAddr(in.RemoteAddr)
+	trusted := uc.trustedProxies.Trusted(peer)
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	host := in.Host
+
+	xff := ""
+	if peer.IsValid() {
+		xff = peer.String()
+	}
+	if trusted {
+		if prior := strings.Join(in.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+			xff = prior + ", " + xff
+		}
+		if p := in.Header.Get("X-Forwarded-Proto"); p != "" {
+			proto = p
+		}
+		if h := in.Header.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+	}
+	if xff != "" {
+		out.Header.Set("X-Forwarded-For", xff)
+	}
+	out.Header.Set("X-Forwarded-Proto", proto)
+	out.Header.Set("X-Forwarded-Host", host)
+
+	// Our own element describes this hop, so it uses what we saw rather than what a proxy claimed
+	element := "for=" + forwardedNode(in.RemoteAddr)
+	if in.Host != "" {
+		element += ";host=" + quoteForwarded(in.Host)
+	}
+	if in.TLS != nil {
+		element += ";proto=https"
+	} else {
+		element += ";proto=http"
+	}
+	if prior := strings.Join(in.Header.Values("Forwarded"), ", "); trusted && prior != "" {
+		element = prior + ", " + element
+	}
+	out.Header.Set("Forwarded", element)
+	setClientCertHeader(in, out, trusted)
+}
+
+// forwardedNode formats a node for the Forwarded "for" parameter, IPv6 addresses are bracketed and quoted.
+func forwardedNode(remoteAddr string) string {
+	addr := clientip.ParseAddr(remoteAddr)
+	switch {
+	case !addr.IsValid():
+		return "unknown"
+	case addr.Is6():
+		return `"[` + addr.String() + `]"`
+	}
+	return addr.String()
+}
+
+// quoteForwarded quotes values that are not an RFC 7230 token, such as host:port.
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
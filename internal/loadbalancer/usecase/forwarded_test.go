@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/clientip"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	b, _ := models.NewBackend(upstream.URL)
+	pool := models.NewServerPool()
+	pool.AddBackend(b)
+	resolver, _ := clientip.NewResolver([]string{"10.0.0.0/8"})
+	uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithTrustedProxies(resolver))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		headers    map[string]string
+		expected   map[string]string
+	}{
+		{
+			name:       "UntrustedClientHeadersAreReplaced",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Forwarded-Host": "evil.test", "Forwarded": "for=1.1.1.1"},
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "shop.example.com",
+				"Forwarded":         "for=203.0.113.7;host=shop.example.com;proto=http",
+			},
+		},
+		{
+			name:       "TrustedProxyChainIsExtended",
+			remoteAddr: "10.0.0.5:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.4", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com", "Forwarded": "for=198.51.100.4;proto=https"},
+			expected: map[string]string{
+				"X-Forwarded-For":   "198.51.100.4, 10.0.0.5",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "www.example.com",
+				"Forwarded":         "for=198.51.100.4;proto=https, for=10.0.0.5;host=shop.example.com;proto=http",
+			},
+		},
+		{
+			name:       "IPv6AndTLS",
+			remoteAddr: "[2001:db8::1]:443",
+			tls:        true,
+			expected: map[string]string{
+				"X-Forwarded-For":   "2001:db8::1",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         `for="[2001:db8::1]";host=shop.example.com;proto=https`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = "shop.example.com"
+			req.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			err := uc.HandleRequest(req, w)
+
+			assert.NoError(t, err, "Expected no error")
+			for k, v := range tt.expected {
+				assert.Equal(t, v, got.Get(k), "Unexpected %s", k)
+			}
+		})
+	}
+
+	t.Run("HostWithPortIsQuoted", func(t *testing.T) {
+		assert.Equal(t, `"example.com:8080"`, quoteForwarded("example.com:8080"), "Expected a quoted string")
+		assert.Equal(t, "example.com", quoteForwarded("example.com"), "Expected a bare token")
+	})
+}
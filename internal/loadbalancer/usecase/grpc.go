@@ -0,0 +1,184 @@
+package usecase
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gRPC status codes a RetryPolicy can retry on, named as in Envoy's retry_on.
+var grpcRetryCodes = map[string]int{
+	"cancelled":          1,
+	"unknown":            2,
+	"deadline-exceeded":  4,
+	"resource-exhausted": 8,
+	"internal":           13,
+	"unavailable":        14,
+}
+
+const (
+	grpcDeadlineExceeded = 4
+	grpcUnavailable      = 14
+)
+
+func isGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus reads grpc-status from headers or (announced or not) trailers copied into h.
+func grpcStatus(h http.Header) (int, bool) {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		v = h.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	if v == "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(v)
+	return code, err == nil
+}
+
+// grpcHTTPStatus maps a gRPC status onto the HTTP status with the same meaning, so outlier detection treats both alike.
+func grpcHTTPStatus(code int) int {
+	switch code {
+	case 0:
+		return http.StatusOK
+	case 1:
+		return 499 // client closed request
+	case 3, 9, 11:
+		return http.StatusBadRequest
+	case 4:
+		return http.StatusGatewayTimeout
+	case 5:
+		return http.StatusNotFound
+	case 6, 10:
+		return http.StatusConflict
+	case 7:
+		return http.StatusForbidden
+	case 8:
+		return http.StatusTooManyRequests
+	case 12:
+		return http.StatusNotImplemented
+	case 14:
+		return http.StatusServiceUnavailable
+	case 16:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+func (p RetryPolicy) retryableGRPC(code int) bool {
+	for _, name := range p.RetryOnGRPC {
+		if grpcRetryCodes[name] == code {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+unprocessed reports whether a failed attempt left the call unhandled by the backend: it could not be
+connected to, or refused the call with a trailers-only gRPC status. A reset or timeout may come after
+the backend acted on the call, so retrying those could run it twice.
+*/
+func unprocessed(err error) bool {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.grpc
+	}
+	return classifyError(err) == RetryOnConnectFailure
+}
+
+// responseStatus is the outcome of a delivered response, with gRPC errors sent as 200 + grpc-status translated.
+func responseStatus(pw *proxyWriter) int {
+	if code, ok := grpcStatus(pw.Header()); ok {
+		return grpcHTTPStatus(code)
+	}
+	return pw.statusCode
+}
+
+// writeGatewayError reports a failure we generated ourselves, as a trailers-only response to gRPC clients.
+func writeGatewayError(w http.ResponseWriter, req *http.Request, status int) {
+	if !isGRPC(req) {
+		w.WriteHeader(status)
+		return
+	}
+	code := grpcUnavailable
+	if status == http.StatusGatewayTimeout {
+		code = grpcDeadlineExceeded
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", http.StatusText(status))
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+teeBody records a streamed request body while the first attempt reads it. gRPC requests have no
+length up front and may be long-lived streams, so they can't be buffered before proxying; instead
+a retry is allowed when the body was not touched yet, or was read to the end within limit.
+*/
+type teeBody struct {
+	src      io.ReadCloser
+	limit    int64
+	mux      sync.Mutex
+	buf      bytes.Buffer
+	started  bool
+	eof      bool
+	overflow bool
+}
+
+func newTeeBody(req *http.Request, limit int64) *teeBody {
+	t := &teeBody{src: req.Body, limit: limit}
+	req.Body = t
+	return t
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.src.Read(p)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.started = true
+	if !t.overflow {
+		if int64(t.buf.Len()+n) > t.limit {
+			t.overflow = true
+			t.buf = bytes.Buffer{}
+		} else {
+			t.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	return n, err
+}
+
+// Close is left to the last attempt, the transport closing the body must not end a retry's stream.
+func (t *teeBody) Close() error {
+	return nil
+}
+
+/*
+replay reports whether another attempt may be made. It returns the recorded body when there is
+one to resend, nil when the original body is still untouched and can be read by the next attempt.
+*/
+func (t *teeBody) replay() (*replayableBody, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	switch {
+	case !t.started:
+		return nil, true
+	case t.eof && !t.overflow:
+		return &replayableBody{data: bytes.Clone(t.buf.Bytes())}, true
+	}
+	return nil, false
+}
+
+func (t *teeBody) replayable() bool {
+	_, ok := t.replay()
+	return ok
+}
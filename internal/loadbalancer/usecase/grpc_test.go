@@ -0,0 +1,228 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cTransport speaks prior-knowledge HTTP/2 over plain TCP, like a gRPC client without TLS.
+func h2cTransport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+func newH2CServer(handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+}
+
+func TestGRPCProxying(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	newFrontend := func(upstream *httptest.Server) (*LoadBalancerUseCase, *httptest.Server) {
+		pool := models.NewServerPool()
+		if upstream != nil {
+			b, _ := models.NewBackend(upstream.URL)
+			pool.AddBackend(b)
+		}
+		policy := DefaultRetryPolicy()
+		policy.BackoffBase = 0
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, h2cTransport(), WithRetryPolicy(policy))
+		frontend := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			uc.HandleRequest(r, w)
+		})
+		return uc, frontend
+	}
+	client := &http.Client{Transport: h2cTransport()}
+	grpcRequest := func(url string, body io.Reader) *http.Request {
+		req, _ := http.NewRequest("POST", url+"/echo.Echo/Say", body)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+		return req
+	}
+
+	t.Run("TrailersArePreserved", func(t *testing.T) {
+		upstream := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, 2, r.ProtoMajor, "Expected HTTP/2 to the backend")
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.Write(body)
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set("Grpc-Message", "ok")
+		})
+		defer upstream.Close()
+		_, frontend := newFrontend(upstream)
+		defer frontend.Close()
+
+		resp, err := client.Do(grpcRequest(frontend.URL, strings.NewReader("hello")))
+		assert.NoError(t, err, "Expected the call to succeed")
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, 2, resp.ProtoMajor, "Expected HTTP/2 from the listener")
+		assert.Equal(t, "hello", string(body), "Expected the message to be echoed")
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"), "Expected the grpc-status trailer")
+		assert.Equal(t, "ok", resp.Trailer.Get("Grpc-Message"), "Expected the grpc-message trailer")
+	})
+
+	t.Run("BidirectionalStreaming", func(t *testing.T) {
+		upstream := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.WriteHeader(http.StatusOK)
+			http.NewResponseController(w).EnableFullDuplex()
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				w.Write([]byte("echo " + scanner.Text() + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		})
+		defer upstream.Close()
+		_, frontend := newFrontend(upstream)
+		defer frontend.Close()
+
+		pr, pw := io.Pipe()
+		// Like a gRPC client, send the first message before the response headers arrive.
+		go pw.Write([]byte("one\n"))
+		resp, err := client.Do(grpcRequest(frontend.URL, pr))
+		assert.NoError(t, err, "Expected the stream to open")
+		defer resp.Body.Close()
+		reader := bufio.NewReader(resp.Body)
+		for i, msg := range []string{"one", "two"} {
+			if i > 0 {
+				pw.Write([]byte(msg + "\n"))
+			}
+			line, err := reader.ReadString('\n')
+			assert.NoError(t, err, "Expected a reply while the request is still open")
+			assert.Equal(t, "echo "+msg+"\n", line, "Expected the reply to the last message")
+		}
+		pw.Close()
+	})
+
+	t.Run("TrailersOnlyUnavailableIsRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		upstream := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/grpc")
+			if calls.Add(1) == 1 {
+				w.Header().Set("Grpc-Status", "14")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Write(body)
+			w.Header().Set("Grpc-Status", "0")
+		})
+		defer upstream.Close()
+		_, frontend := newFrontend(upstream)
+		defer frontend.Close()
+
+		resp, err := client.Do(grpcRequest(frontend.URL, strings.NewReader("payload")))
+		assert.NoError(t, err, "Expected the call to succeed")
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, int32(2), calls.Load(), "Expected one retry")
+		assert.Equal(t, "payload", string(body), "Expected the request body to be replayed")
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"), "Expected the retry's status")
+	})
+
+	t.Run("ResetAfterSendIsNotRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		// HTTP/1.1 to the backend, so dropping the connection surfaces as a reset
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			io.ReadAll(r.Body)
+			conn, _, _ := http.NewResponseController(w).Hijack()
+			conn.Close()
+		}))
+		defer upstream.Close()
+		pool := models.NewServerPool()
+		b, _ := models.NewBackend(upstream.URL)
+		pool.AddBackend(b)
+		policy := DefaultRetryPolicy()
+		policy.BackoffBase = 0
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithRetryPolicy(policy))
+		frontend := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			uc.HandleRequest(r, w)
+		})
+		defer frontend.Close()
+
+		resp, err := client.Do(grpcRequest(frontend.URL, strings.NewReader("transfer")))
+		assert.NoError(t, err, "Expected a response")
+		resp.Body.Close()
+
+		assert.Equal(t, int32(1), calls.Load(), "A call the backend may have processed should not be sent again")
+		assert.Equal(t, "14", resp.Header.Get("Grpc-Status"), "Expected UNAVAILABLE")
+	})
+
+	t.Run("OtherStatusesPassThrough", func(t *testing.T) {
+		var calls atomic.Int32
+		upstream := newH2CServer(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Grpc-Status", "3")
+			w.WriteHeader(http.StatusOK)
+		})
+		defer upstream.Close()
+		uc, frontend := newFrontend(upstream)
+		defer frontend.Close()
+
+		resp, err := client.Do(grpcRequest(frontend.URL, strings.NewReader("bad")))
+		assert.NoError(t, err, "Expected the call to complete")
+		resp.Body.Close()
+
+		assert.Equal(t, int32(1), calls.Load(), "INVALID_ARGUMENT should not be retried")
+		assert.Equal(t, "3", resp.Header.Get("Grpc-Status"), "Expected the status to reach the client")
+		requests, successes := uc.Pool.Backends[0].TakeOutlierWindow()
+		assert.Equal(t, uint64(1), requests, "Expected the call to be recorded")
+		assert.Equal(t, uint64(1), successes, "A client error should not count against the backend")
+	})
+
+	t.Run("GatewayErrorsUseGRPCStatus", func(t *testing.T) {
+		_, frontend := newFrontend(nil)
+		defer frontend.Close()
+
+		resp, err := client.Do(grpcRequest(frontend.URL, strings.NewReader("x")))
+		assert.NoError(t, err, "Expected a response")
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "gRPC errors are sent with HTTP 200")
+		assert.Equal(t, "14", resp.Header.Get("Grpc-Status"), "Expected UNAVAILABLE")
+	})
+}
+
+func TestGRPCStatusMapping(t *testing.T) {
+	pw := newProxyWriter(httptest.NewRecorder())
+	pw.WriteHeader(http.StatusOK)
+	assert.Equal(t, http.StatusOK, responseStatus(pw), "Expected the HTTP status without grpc-status")
+
+	pw.Header().Set(http.TrailerPrefix+"Grpc-Status", "14")
+	assert.Equal(t, http.StatusServiceUnavailable, responseStatus(pw), "Expected UNAVAILABLE to count as 503")
+
+	pw.Header().Set("Grpc-Status", "4")
+	assert.Equal(t, http.StatusGatewayTimeout, responseStatus(pw), "Expected DEADLINE_EXCEEDED to count as 504")
+
+	policy := DefaultRetryPolicy()
+	assert.True(t, policy.retryableError(&upstreamStatusError{code: 503, grpc: true, grpcCode: 14}), "UNAVAILABLE is retried by default")
+	assert.False(t, policy.retryableError(&upstreamStatusError{code: 500, grpc: true, grpcCode: 13}), "INTERNAL is not retried by default")
+}
@@ -0,0 +1,195 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"hash/fnv"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Request attributes a HashPolicy can key on.
+const (
+	HashKeyClientIP string = "client_ip"
+	HashKeyHeader   string = "header"
+	HashKeyCookie   string = "cookie"
+	HashKeyPath     string = "path"
+)
+
+const DefaultVirtualNodes int = 100
+
+// HashPolicy selects the request attribute consistent hashing routes on.
+type HashPolicy struct {
+	Key          string
+	Name         string // header or cookie name
+	VirtualNodes int    // ring points per unit of backend weight
+}
+
+func DefaultHashPolicy() HashPolicy {
+	return HashPolicy{
+		Key:          HashKeyClientIP,
+		VirtualNodes: DefaultVirtualNodes,
+	}
+}
+
+func HashPolicyFromConfig(cfg utils.HashConfig) HashPolicy {
+	policy := DefaultHashPolicy()
+	if cfg.Key != "" {
+		policy.Key = cfg.Key
+	}
+	policy.Name = cfg.Name
+	if cfg.VirtualNodes > 0 {
+		policy.VirtualNodes = cfg.VirtualNodes
+	}
+	return policy
+}
+
+func WithHashPolicy(policy HashPolicy) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.hash = policy
+	}
+}
+
+// key extracts the value to hash from rc, empty when the request does not carry it.
+func (p HashPolicy) key(rc *RequestContext) string {
+	switch p.Key {
+	case HashKeyHeader:
+		return rc.Header.Get(p.Name)
+	case HashKeyCookie:
+		if rc.Request == nil {
+			return ""
+		}
+		if c, err := rc.Request.Cookie(p.Name); err == nil {
+			return c.Value
+		}
+		return ""
+	case HashKeyPath:
+		if rc.Request == nil {
+			return ""
+		}
+		return rc.Request.URL.Path
+	default:
+		host, _, err := net.SplitHostPort(rc.ClientAddr)
+		if err != nil {
+			return rc.ClientAddr
+		}
+		return host
+	}
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *models.Backend
+}
+
+/*
+hashRing is a ring-hash (Karger) over every backend in the pool, each owning weight*VirtualNodes points.
+Unavailable backends stay on the ring and are skipped at lookup, so an ejection only moves the keys
+that backend owned, and the ring is rebuilt only when pool membership or weights change.
+*/
+type hashRing struct {
+	points  []ringPoint
+	members []*models.Backend
+	weights []int
+}
+
+func newHashRing(backends []*models.Backend, virtualNodes int) *hashRing {
+	ring := &hashRing{
+		members: slices.Clone(backends),
+		weights: make([]int, len(backends)),
+	}
+	for i, b := range backends {
+		ring.weights[i] = b.GetWeight()
+		id := b.URL.String()
+		for v := range ring.weights[i] * virtualNodes {
+			ring.points = append(ring.points, ringPoint{hash: hashString(id + "#" + strconv.Itoa(v)), backend: b})
+		}
+	}
+	slices.SortFunc(ring.points, func(a, b ringPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+	return ring
+}
+
+func (r *hashRing) matches(backends []*models.Backend) bool {
+	if !slices.Equal(r.members, backends) {
+		return false
+	}
+	for i, b := range backends {
+		if b.GetWeight() != r.weights[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup walks clockwise from key to the first available backend not in exclude.
+func (r *hashRing) lookup(key uint64, exclude map[*models.Backend]bool) *models.Backend {
+	if len(r.points) == 0 {
+		return nil
+	}
+	start, _ := slices.BinarySearchFunc(r.points, key, func(p ringPoint, k uint64) int {
+		switch {
+		case p.hash < k:
+			return -1
+		case p.hash > k:
+			return 1
+		}
+		return 0
+	})
+	for i := range len(r.points) {
+		b := r.points[(start+i)%len(r.points)].backend
+		if b.IsAvailable() && !exclude[b] {
+			return b
+		}
+	}
+	return nil
+}
+
+// hashString is FNV-1a followed by a splitmix64 finalizer, FNV alone clusters similar keys on the ring.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+/*
+hashBalancer routes a request to the backend owning its key on the ring and honours rc.Exclude by walking
+on to the next backend clockwise, so a retried key still lands somewhere deterministic.
+Requests without a key (no header, no cookie) fall back to round robin.
+*/
+type hashBalancer struct {
+	policy   HashPolicy
+	fallback roundRobinBalancer
+	mux      sync.Mutex
+	ring     *hashRing
+}
+
+func (h *hashBalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	key := h.policy.key(rc)
+	if key == "" {
+		return h.fallback.Select(pool, rc)
+	}
+	backends := pool.AllBackends()
+	h.mux.Lock()
+	if h.ring == nil || !h.ring.matches(backends) {
+		h.ring = newHashRing(backends, max(h.policy.VirtualNodes, 1))
+	}
+	ring := h.ring
+	h.mux.Unlock()
+	return ring.lookup(hashString(key), rc.Exclude)
+}
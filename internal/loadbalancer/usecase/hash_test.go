@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestFrom(ip string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip + ":43210"
+	return req
+}
+
+func TestHashSelectionKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy HashPolicy
+		first  func() *http.Request
+		second func() *http.Request
+	}{
+		{
+			name:   "ClientIPIgnoresPort",
+			policy: DefaultHashPolicy(),
+			first:  func() *http.Request { return requestFrom("192.168.1.10") },
+			second: func() *http.Request {
+				req := requestFrom("192.168.1.10")
+				req.RemoteAddr = "192.168.1.10:5555"
+				return req
+			},
+		},
+		{
+			name:   "Header",
+			policy: HashPolicy{Key: HashKeyHeader, Name: "X-User", VirtualNodes: DefaultVirtualNodes},
+			first: func() *http.Request {
+				req := requestFrom("192.168.1.10")
+				req.Header.Set("X-User", "alice")
+				return req
+			},
+			second: func() *http.Request {
+				req := requestFrom("172.16.0.1")
+				req.Header.Set("X-User", "alice")
+				return req
+			},
+		},
+		{
+			name:   "Cookie",
+			policy: HashPolicy{Key: HashKeyCookie, Name: "session", VirtualNodes: DefaultVirtualNodes},
+			first: func() *http.Request {
+				req := requestFrom("192.168.1.10")
+				req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+				return req
+			},
+			second: func() *http.Request {
+				req := requestFrom("172.16.0.1")
+				req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+				return req
+			},
+		},
+		{
+			name:   "Path",
+			policy: HashPolicy{Key: HashKeyPath, VirtualNodes: DefaultVirtualNodes},
+			first:  func() *http.Request { return httptest.NewRequest("GET", "/users/42?a=1", nil) },
+			second: func() *http.Request { return httptest.NewRequest("GET", "/users/42?b=2", nil) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, _ := newTestUseCase(t, ConsistentHash, 5, WithHashPolicy(tt.policy))
+			first := uc.SelectBackendForRequest(tt.first())
+			for range 10 {
+				assert.Same(t, first, uc.SelectBackendForRequest(tt.second()), "Same key should always reach the same backend")
+			}
+		})
+	}
+}
+
+func TestHashSelectionMinimalDisruption(t *testing.T) {
+	const keys = 2000
+	uc, backends := newTestUseCase(t, ConsistentHash, 5, WithHashPolicy(DefaultHashPolicy()))
+
+	assign := func() map[int]*models.Backend {
+		m := make(map[int]*models.Backend, keys)
+		for i := range keys {
+			m[i] = uc.SelectBackendForRequest(requestFrom(fmt.Sprintf("10.1.%d.%d", i/256, i%256)))
+		}
+		return m
+	}
+	before := assign()
+
+	counts := map[*models.Backend]int{}
+	for _, b := range before {
+		counts[b]++
+	}
+	for _, b := range backends {
+		assert.InDelta(t, keys/5, counts[b], keys/10, "Keys should be spread evenly across backends")
+	}
+
+	t.Run("RemovedBackendOnlyMovesItsKeys", func(t *testing.T) {
+		backends[2].SetAlive(false)
+		defer backends[2].SetAlive(true)
+		after := assign()
+		for i := range keys {
+			if before[i] != backends[2] {
+				assert.Same(t, before[i], after[i], "Keys of healthy backends should not move")
+			} else {
+				assert.NotSame(t, backends[2], after[i], "Keys of the removed backend should move")
+			}
+		}
+	})
+
+	t.Run("AddedBackendTakesAFairShare", func(t *testing.T) {
+		extra, _ := models.NewBackend("http://10.0.0.99:8080")
+		uc.Pool.AddBackend(extra)
+		after := assign()
+		moved := 0
+		for i := range keys {
+			if after[i] != before[i] {
+				moved++
+				assert.Same(t, extra, after[i], "Keys should only move to the new backend")
+			}
+		}
+		assert.InDelta(t, keys/6, moved, keys/12, "About 1/6 of the keys should move to the new backend")
+	})
+}
+
+func TestHashSelectionRetryGoesToNextBackend(t *testing.T) {
+	uc, _ := newTestUseCase(t, ConsistentHash, 3, WithHashPolicy(DefaultHashPolicy()))
+	req := requestFrom("192.168.1.10")
+	first := uc.SelectBackendForRequest(req)
+
+	tried := map[*models.Backend]bool{first: true}
+	second := uc.selectUntried(req, tried, true)
+	assert.NotNil(t, second, "Expected a fallback backend")
+	assert.NotSame(t, first, second, "Retry should go to a different backend")
+	assert.Same(t, second, uc.selectUntried(req, tried, true), "Fallback should be deterministic")
+}
@@ -0,0 +1,238 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const HeaderRequestID = "X-Request-Id"
+
+// Variables available to header rule values as ${name}.
+const (
+	HeaderVarClientIP  = "client_ip"
+	HeaderVarRequestID = "request_id"
+	HeaderVarRoute     = "route"
+	HeaderVarBackend   = "backend"
+)
+
+type headerPair struct {
+	name, value string
+}
+
+// HeaderOps is one direction of a HeaderRules set, see utils.HeaderOpsConfig for the order they run in.
+type HeaderOps struct {
+	rename []headerPair
+	remove []string
+	set    []headerPair
+	add    []headerPair
+}
+
+// HeaderRules edits request headers on the way to the backend and response headers on the way back.
+type HeaderRules struct {
+	Request  HeaderOps
+	Response HeaderOps
+}
+
+// HeaderRulesFromConfig returns nil when no rule is configured.
+func HeaderRulesFromConfig(cfg utils.HeaderRulesConfig) (*HeaderRules, error) {
+	request, err := headerOpsFromConfig(cfg.Request)
+	if err != nil {
+		return nil, fmt.Errorf("request headers: %w", err)
+	}
+	response, err := headerOpsFromConfig(cfg.Response)
+	if err != nil {
+		return nil, fmt.Errorf("response headers: %w", err)
+	}
+	if request.empty() && response.empty() {
+		return nil, nil
+	}
+	return &HeaderRules{Request: request, Response: response}, nil
+}
+
+// WithHeaderRules sets the pool-wide header rules, route rules are applied after them.
+func WithHeaderRules(rules *HeaderRules) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.headers = rules
+	}
+}
+
+func headerOpsFromConfig(cfg utils.HeaderOpsConfig) (HeaderOps, error) {
+	var ops HeaderOps
+	var err error
+	if ops.rename, err = headerPairs(cfg.Rename, false); err != nil {
+		return ops, err
+	}
+	for _, name := range cfg.Remove {
+		if !validHeaderName(name) {
+			return ops, fmt.Errorf("invalid header name %q", name)
+		}
+		ops.remove = append(ops.remove, http.CanonicalHeaderKey(name))
+	}
+	if ops.set, err = headerPairs(cfg.Set, true); err != nil {
+		return ops, err
+	}
+	if ops.add, err = headerPairs(cfg.Add, true); err != nil {
+		return ops, err
+	}
+	return ops, nil
+}
+
+// headerPairs sorts the map so rules run in a stable order, and checks names and template variables.
+func headerPairs(m map[string]string, templated bool) ([]headerPair, error) {
+	pairs := make([]headerPair, 0, len(m))
+	for name, value := range m {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if !templated {
+			if !validHeaderName(value) {
+				return nil, fmt.Errorf("invalid header name %q", value)
+			}
+			value = http.CanonicalHeaderKey(value)
+		} else if err := checkHeaderTemplate(value); err != nil {
+			return nil, fmt.Errorf("header %q: %w", name, err)
+		}
+		pairs = append(pairs, headerPair{http.CanonicalHeaderKey(name), value})
+	}
+	slices.SortFunc(pairs, func(a, b headerPair) int { return strings.Compare(a.name, b.name) })
+	return pairs, nil
+}
+
+func checkHeaderTemplate(value string) error {
+	var unknown string
+	expandHeaderTemplate(value, func(name string) string {
+		switch name {
+		case HeaderVarClientIP, HeaderVarRequestID, HeaderVarRoute, HeaderVarBackend:
+		default:
+			if unknown == "" {
+				unknown = name
+			}
+		}
+		return ""
+	})
+	if unknown != "" {
+		return fmt.Errorf("unknown variable ${%s}", unknown)
+	}
+	return nil
+}
+
+// expandHeaderTemplate replaces ${name} with lookup(name) and $$ with $. Any other $ is kept as is, so "$5 off" needs no escaping.
+func expandHeaderTemplate(value string, lookup func(name string) string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(value, '$')
+		if i < 0 || i == len(value)-1 {
+			b.WriteString(value)
+			return b.String()
+		}
+		b.WriteString(value[:i])
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			value = value[i+2:]
+			continue
+		case '{':
+			if end := strings.IndexByte(value[i+2:], '}'); end >= 0 {
+				b.WriteString(lookup(value[i+2 : i+2+end]))
+				value = value[i+3+end:]
+				continue
+			}
+		}
+		b.WriteByte('$')
+		value = value[i+1:]
+	}
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !isTokenChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ops *HeaderOps) empty() bool {
+	return len(ops.rename) == 0 && len(ops.remove) == 0 && len(ops.set) == 0 && len(ops.add) == 0
+}
+
+func (ops *HeaderOps) apply(h http.Header, vars map[string]string) {
+	for _, r := range ops.rename {
+		if values, ok := h[r.name]; ok {
+			delete(h, r.name)
+			h[r.value] = append(h[r.value], values...)
+		}
+	}
+	for _, name := range ops.remove {
+		h.Del(name)
+	}
+	expand := func(s string) string {
+		return expandHeaderTemplate(s, func(name string) string { return vars[name] })
+	}
+	for _, s := range ops.set {
+		h.Set(s.name, expand(s.value))
+	}
+	for _, a := range ops.add {
+		h.Add(a.name, expand(a.value))
+	}
+}
+
+// headerRules returns the rule sets that apply to the request, pool first then route.
+func (uc *LoadBalancerUseCase) headerRules(ctx context.Context) []*HeaderRules {
+	var rules []*HeaderRules
+	if uc.headers != nil {
+		rules = append(rules, uc.headers)
+	}
+	if route := RouteFromContext(ctx); route != nil && route.HeaderRules != nil {
+		rules = append(rules, route.HeaderRules)
+	}
+	return rules
+}
+
+func headerVars(req *http.Request, backend *models.Backend) map[string]string {
+	vars := map[string]string{
+		HeaderVarRequestID: RequestIDFromContext(req.Context()),
+	}
+	if addr := clientip.FromRequest(req); addr.IsValid() {
+		vars[HeaderVarClientIP] = addr.String()
+	}
+	if route := RouteFromContext(req.Context()); route != nil {
+		vars[HeaderVarRoute] = route.Name
+	}
+	if backend != nil {
+		vars[HeaderVarBackend] = backend.URL.Host
+	}
+	return vars
+}
+
+type requestIDKey struct{}
+
+// withRequestID keeps the client's X-Request-Id, or makes one up, so every attempt and both directions see the same ID.
+func withRequestID(req *http.Request) *http.Request {
+	id := req.Header.Get(HeaderRequestID)
+	if id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
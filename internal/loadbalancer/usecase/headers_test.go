@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderRules(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("X-Debug-Trace", "internal")
+		w.Header().Set("X-Cache", "HIT")
+	}))
+	defer upstream.Close()
+	b, _ := models.NewBackend(upstream.URL)
+	pool := models.NewServerPool()
+	pool.AddBackend(b)
+
+	poolRules, err := HeaderRulesFromConfig(utils.HeaderRulesConfig{
+		Request: utils.HeaderOpsConfig{
+			Set:    map[string]string{"X-Env": "prod", "x-request-id": "${request_id}"},
+			Remove: []string{"Cookie"},
+		},
+		Response: utils.HeaderOpsConfig{
+			Remove: []string{"server", "X-Debug-Trace"},
+			Add:    map[string]string{"X-Served-By": "${backend}"},
+		},
+	})
+	assert.NoError(t, err, "Expected valid pool rules")
+	uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithHeaderRules(poolRules))
+
+	routes, err := RoutesFromConfig([]utils.RouteConfig{{
+		Name:       "api",
+		PathPrefix: "/api",
+		HeaderRules: utils.HeaderRulesConfig{
+			Request: utils.HeaderOpsConfig{
+				Rename: map[string]string{"X-Token": "Authorization"},
+				Set:    map[string]string{"X-Env": "prod-api", "X-Client": "${client_ip} via ${route}"},
+			},
+			Response: utils.HeaderOpsConfig{
+				Rename: map[string]string{"X-Cache": "X-Upstream-Cache"},
+			},
+		},
+	}})
+	assert.NoError(t, err, "Expected valid routes")
+	router, err := NewRouter(map[string]*LoadBalancerUseCase{utils.DefaultPool: uc}, routes)
+	assert.NoError(t, err, "Expected valid router")
+
+	t.Run("PoolRules", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Cookie", "session=1")
+		w := httptest.NewRecorder()
+		router.HandleRequest(req, w)
+
+		assert.Equal(t, "prod", got.Get("X-Env"), "Expected the pool value")
+		assert.Empty(t, got.Get("Cookie"), "Expected the cookie to be removed")
+		assert.Len(t, got.Get("X-Request-Id"), 32, "Expected a generated request ID")
+		assert.Empty(t, w.Header().Get("Server"), "Expected Server to be stripped")
+		assert.Empty(t, w.Header().Get("X-Debug-Trace"), "Expected the debug header to be hidden")
+		assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), w.Header().Get("X-Served-By"), "Expected the backend host")
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"), "Route rules should not apply")
+	})
+
+	t.Run("RouteRulesRunAfterPoolRules", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		req.RemoteAddr = "198.51.100.4:1234"
+		req.Header.Set("X-Token", "secret")
+		req.Header.Set("X-Request-Id", "abc-123")
+		w := httptest.NewRecorder()
+		router.HandleRequest(req, w)
+
+		assert.Equal(t, "prod-api", got.Get("X-Env"), "Expected the route to override the pool")
+		assert.Equal(t, "198.51.100.4 via api", got.Get("X-Client"), "Expected the template to be expanded")
+		assert.Equal(t, "secret", got.Get("Authorization"), "Expected the header to be renamed")
+		assert.Empty(t, got.Get("X-Token"), "Expected the old name to be gone")
+		assert.Equal(t, "abc-123", got.Get("X-Request-Id"), "Expected the client's request ID to be kept")
+		assert.Equal(t, "HIT", w.Header().Get("X-Upstream-Cache"), "Expected the response header to be renamed")
+	})
+
+	t.Run("InvalidRules", func(t *testing.T) {
+		_, err := HeaderRulesFromConfig(utils.HeaderRulesConfig{Request: utils.HeaderOpsConfig{Set: map[string]string{"X-Env": "${hostname}"}}})
+		assert.Error(t, err, "Expected an unknown variable to be rejected")
+		_, err = HeaderRulesFromConfig(utils.HeaderRulesConfig{Response: utils.HeaderOpsConfig{Remove: []string{"Bad Header"}}})
+		assert.Error(t, err, "Expected an invalid header name to be rejected")
+		rules, err := HeaderRulesFromConfig(utils.HeaderRulesConfig{})
+		assert.NoError(t, err, "Expected no error without rules")
+		assert.Nil(t, rules, "Expected no rules")
+	})
+
+	t.Run("LiteralDollarSigns", func(t *testing.T) {
+		_, err := HeaderRulesFromConfig(utils.HeaderRulesConfig{Response: utils.HeaderOpsConfig{Set: map[string]string{"X-Promo": "$5 off", "X-Price": "$$9 $"}}})
+		assert.NoError(t, err, "Expected a $ outside ${name} to be accepted")
+
+		vars := map[string]string{HeaderVarRoute: "api"}
+		lookup := func(name string) string { return vars[name] }
+		for value, expected := range map[string]string{
+			"$5 off":           "$5 off",
+			"$$":               "$",
+			"$${route}":        "${route}",
+			"cost: $$${route}": "cost: $api",
+			"${route":          "${route",
+			"${route}$":        "api$",
+		} {
+			assert.Equal(t, expected, expandHeaderTemplate(value, lookup), "Unexpected expansion of %q", value)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/logger"
+	"GoRelay/pkg/metrics"
+)
+
+var (
+	backendHealth = metrics.Default.NewGaugeVec("gorelay_backend_health",
+		"1 for the health state a backend is in, 0 for the others.", "pool", "backend", "state")
+	healthTransitions = metrics.Default.NewCounterVec("gorelay_health_transitions_total",
+		"Health state changes, by the state entered.", "pool", "backend", "state")
+)
+
+// HealthEvent is a backend's health state transition, as published by the HealthScheduler.
+type HealthEvent struct {
+	Pool    string
+	Backend string
+	models.Transition
+}
+
+// LogHealthEvents logs every event until the channel is closed: a warning when a backend leaves rotation, info when it is back.
+func LogHealthEvents(events <-chan HealthEvent, log *logger.Logger) {
+	for e := range events {
+		args := []any{"pool", e.Pool, "backend", e.Backend, "from", e.From, "to", e.To, "reason", e.Reason}
+		if e.To == models.HealthUp {
+			log.Info("backend healthy", args...)
+		} else {
+			log.Warn("backend unhealthy", args...)
+		}
+	}
+}
+
+// RecordHealthMetrics keeps the health metrics up to date from events until the channel is closed.
+func RecordHealthMetrics(events <-chan HealthEvent) {
+	for e := range events {
+		for _, state := range []models.HealthState{models.HealthUp, models.HealthDown, models.HealthFlapping} {
+			v := 0.0
+			if state == e.To {
+				v = 1
+			}
+			backendHealth.With(e.Pool, e.Backend, string(state)).Set(v)
+		}
+		healthTransitions.With(e.Pool, e.Backend, string(e.To)).Inc()
+	}
+}
@@ -0,0 +1,207 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/events"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProbeConcurrency int     = 32
+	DefaultProbeJitter      float64 = 0.1
+)
+
+/*
+HealthScheduler runs the active health checks of every pool. Each backend gets its own probe loop,
+started and stopped as the backend joins or leaves its pool. The first probe lands at a random point
+within the first interval and every later one is jittered, so probes don't fire in lockstep; at most
+the probe concurrency run at once across all pools. While a backend is down it is probed at its pool's
+unhealthy interval, so it is back in rotation sooner. Every probe lands in the backend's health history
+and each change of its HealthState is published as a HealthEvent.
+*/
+type HealthScheduler struct {
+	jitter float64
+	sem    chan struct{}
+	events *events.Bus[HealthEvent]
+
+	mux   sync.Mutex
+	pools []scheduledPool
+	wg    sync.WaitGroup
+}
+
+type scheduledPool struct {
+	name              string
+	uc                *LoadBalancerUseCase
+	interval          time.Duration
+	unhealthyInterval time.Duration
+}
+
+type SchedulerOption func(*HealthScheduler)
+
+// WithProbeConcurrency bounds how many probes run at once, zero keeps DefaultProbeConcurrency.
+func WithProbeConcurrency(n int) SchedulerOption {
+	return func(s *HealthScheduler) {
+		if n > 0 {
+			s.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithProbeJitter spreads each wait by up to that fraction of the interval either way (0.1 is ±10%), zero keeps DefaultProbeJitter.
+func WithProbeJitter(fraction float64) SchedulerOption {
+	return func(s *HealthScheduler) {
+		if fraction > 0 {
+			s.jitter = min(fraction, 1)
+		}
+	}
+}
+
+// WithHealthEvents publishes every health state transition on bus.
+func WithHealthEvents(bus *events.Bus[HealthEvent]) SchedulerOption {
+	return func(s *HealthScheduler) {
+		s.events = bus
+	}
+}
+
+func NewHealthScheduler(opts ...SchedulerOption) *HealthScheduler {
+	s := &HealthScheduler{
+		jitter: DefaultProbeJitter,
+		sem:    make(chan struct{}, DefaultProbeConcurrency),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Schedule adds the pool name, to be probed every interval and every unhealthyInterval (interval when zero) while a backend is down. Call it before Run.
+func (s *HealthScheduler) Schedule(name string, uc *LoadBalancerUseCase, interval, unhealthyInterval time.Duration) {
+	if unhealthyInterval <= 0 {
+		unhealthyInterval = interval
+	}
+	s.mux.Lock()
+	s.pools = append(s.pools, scheduledPool{name: name, uc: uc, interval: interval, unhealthyInterval: unhealthyInterval})
+	s.mux.Unlock()
+}
+
+// Run probes until ctx ends and returns once every probe loop has stopped, after at most one probe timeout.
+func (s *HealthScheduler) Run(ctx context.Context) {
+	s.mux.Lock()
+	pools := s.pools
+	s.mux.Unlock()
+	for _, p := range pools {
+		s.wg.Add(1)
+		go s.watchPool(ctx, p)
+	}
+	s.wg.Wait()
+}
+
+// watchPool keeps one probe loop per member of the pool.
+func (s *HealthScheduler) watchPool(ctx context.Context, p scheduledPool) {
+	defer s.wg.Done()
+	probes := map[*models.Backend]context.CancelFunc{}
+	defer func() {
+		for _, cancel := range probes {
+			cancel()
+		}
+	}()
+	for {
+		// Taken before reading the members, so a change in between isn't missed
+		changed := p.uc.Pool.Changed()
+		members := make(map[*models.Backend]bool)
+		for _, b := range p.uc.Pool.AllBackends() {
+			members[b] = true
+			if _, ok := probes[b]; !ok {
+				probeCtx, cancel := context.WithCancel(ctx)
+				probes[b] = cancel
+				s.wg.Add(1)
+				go s.probeLoop(probeCtx, p, b)
+			}
+		}
+		for b, cancel := range probes {
+			if !members[b] {
+				cancel()
+				delete(probes, b)
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *HealthScheduler) probeLoop(ctx context.Context, p scheduledPool, backend *models.Backend) {
+	defer s.wg.Done()
+	timer := time.NewTimer(rand.N(p.interval + 1))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		start := time.Now()
+		err := probe(p.uc.health, backend)
+		<-s.sem
+		if ctx.Err() != nil {
+			// Removed or shutting down while probing: the result is of no use any more
+			return
+		}
+		result := models.ProbeResult{Time: time.Now(), Duration: time.Since(start), Passed: err == nil}
+		if err != nil {
+			result.Reason = probeReason(err)
+		}
+		if t := backend.RecordProbe(result, p.uc.healthPolicy); t != nil && s.events != nil {
+			s.events.Publish(HealthEvent{Pool: p.name, Backend: backend.URL.String(), Transition: *t})
+		}
+		next := p.interval
+		if !backend.IsAlive() {
+			next = p.unhealthyInterval
+		}
+		timer.Reset(s.jittered(next))
+	}
+}
+
+// probe runs one check, with its failure reason when the checker can tell.
+func probe(checker HealthChecker, backend *models.Backend) error {
+	if prober, ok := checker.(HealthProber); ok {
+		return prober.Probe(backend)
+	}
+	if !checker.CheckHealth(backend) {
+		return errors.New("check failed")
+	}
+	return nil
+}
+
+// probeReason names a failed probe's cause the way the retry policy does, or gives the checker's own message.
+func probeReason(err error) string {
+	switch classifyError(err) {
+	case RetryOnTimeout:
+		return "timeout"
+	case RetryOnConnectFailure:
+		return "connect failed"
+	case RetryOnReset:
+		return "connection reset"
+	}
+	return err.Error()
+}
+
+// jittered returns d moved randomly by up to the jitter fraction either way.
+func (s *HealthScheduler) jittered(d time.Duration) time.Duration {
+	spread := time.Duration(float64(d) * s.jitter)
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + rand.N(2*spread+1)
+}
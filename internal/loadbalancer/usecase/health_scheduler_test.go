@@ -0,0 +1,191 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/events"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// probeCounter counts probes per backend, answering with healthy.
+type probeCounter struct {
+	mux     sync.Mutex
+	probes  map[*models.Backend]int
+	healthy func(*models.Backend) bool
+}
+
+func (c *probeCounter) checker() *mock.HealthRepositoryMock {
+	c.probes = map[*models.Backend]int{}
+	return &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool {
+			c.mux.Lock()
+			c.probes[b]++
+			c.mux.Unlock()
+			return c.healthy == nil || c.healthy(b)
+		},
+	}
+}
+
+func (c *probeCounter) count(b *models.Backend) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.probes[b]
+}
+
+func TestHealthScheduler(t *testing.T) {
+	newPool := func(n int) *models.ServerPool {
+		pool := models.NewServerPool()
+		for range n {
+			b, _ := models.NewBackend("http://localhost:5001")
+			pool.AddBackend(b)
+		}
+		return pool
+	}
+	// run starts the scheduler and stops it, checking it returns, when the test ends.
+	run := func(t *testing.T, s *HealthScheduler) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.Run(ctx)
+			close(done)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Error("Expected the scheduler to stop")
+			}
+		})
+	}
+
+	t.Run("FollowsMembership", func(t *testing.T) {
+		var counter probeCounter
+		pool := newPool(1)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, counter.checker(), &http.Transport{})
+		s := NewHealthScheduler()
+		s.Schedule("pool", uc, 10*time.Millisecond, 0)
+		run(t, s)
+
+		added, _ := models.NewBackend("http://localhost:5002")
+		pool.AddBackend(added)
+		assert.Eventually(t, func() bool { return counter.count(added) >= 2 }, time.Second, 5*time.Millisecond, "Expected a new backend to be probed")
+
+		first := pool.Backends[0]
+		assert.True(t, pool.RemoveBackend(first))
+		time.Sleep(30 * time.Millisecond) // a probe already running may still finish
+		probed := counter.count(first)
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, probed, counter.count(first), "Expected a removed backend to no longer be probed")
+		assert.Greater(t, counter.count(added), 4, "Expected the remaining backend to keep being probed")
+	})
+
+	t.Run("BoundsConcurrency", func(t *testing.T) {
+		var inFlight, peak atomic.Int32
+		checker := &mock.HealthRepositoryMock{
+			CheckHealthFunc: func(b *models.Backend) bool {
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				inFlight.Add(-1)
+				return true
+			},
+		}
+		uc := NewLoadBalancerUseCase(newPool(10), RoundRobin, checker, &http.Transport{})
+		s := NewHealthScheduler(WithProbeConcurrency(2))
+		s.Schedule("pool", uc, 5*time.Millisecond, 0)
+		run(t, s)
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int32(2), peak.Load(), "Expected at most two probes at once")
+	})
+
+	t.Run("ProbesUnhealthyBackendsFaster", func(t *testing.T) {
+		counter := probeCounter{healthy: func(b *models.Backend) bool { return b.URL.Port() != "5001" }}
+		pool := newPool(1)
+		up, _ := models.NewBackend("http://localhost:5002")
+		pool.AddBackend(up)
+		down := pool.Backends[0]
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, counter.checker(), &http.Transport{})
+		s := NewHealthScheduler()
+		s.Schedule("pool", uc, 50*time.Millisecond, 5*time.Millisecond)
+		run(t, s)
+
+		time.Sleep(200 * time.Millisecond)
+		assert.False(t, down.IsAlive())
+		assert.True(t, up.IsAlive())
+		assert.Greater(t, counter.count(down), 2*counter.count(up), "Expected the dead backend to be probed more often")
+	})
+
+	t.Run("PublishesTransitions", func(t *testing.T) {
+		var failing atomic.Bool
+		failing.Store(true)
+		checker := &mock.HealthRepositoryMock{
+			ProbeFunc: func(b *models.Backend) error {
+				if failing.Load() {
+					return fmt.Errorf("dial: %w", context.DeadlineExceeded)
+				}
+				return nil
+			},
+		}
+		pool := newPool(1)
+		bus := events.NewBus[HealthEvent]()
+		received, _ := bus.Subscribe(8)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, checker, &http.Transport{})
+		s := NewHealthScheduler(WithHealthEvents(bus))
+		s.Schedule("api", uc, 5*time.Millisecond, 0)
+		run(t, s)
+
+		next := func() HealthEvent {
+			select {
+			case e := <-received:
+				return e
+			case <-time.After(time.Second):
+				t.Fatal("Expected a health event")
+				return HealthEvent{}
+			}
+		}
+		down := next()
+		assert.Equal(t, "api", down.Pool)
+		assert.Equal(t, pool.Backends[0].URL.String(), down.Backend)
+		assert.Equal(t, models.HealthDown, down.To)
+		assert.Equal(t, "timeout", down.Reason, "Expected the failure to be classified")
+
+		failing.Store(false)
+		assert.Equal(t, models.HealthUp, next().To)
+		probes, _ := pool.Backends[0].HealthHistory()
+		assert.Equal(t, "timeout", probes[0].Reason)
+		assert.True(t, probes[len(probes)-1].Passed)
+	})
+
+	t.Run("SpreadsProbes", func(t *testing.T) {
+		s := NewHealthScheduler(WithProbeJitter(0.2))
+		for range 100 {
+			d := s.jittered(time.Second)
+			assert.True(t, d >= 800*time.Millisecond && d <= 1200*time.Millisecond, "Expected %s within 20%% of the interval", d)
+		}
+	})
+}
+
+func TestProbeReason(t *testing.T) {
+	assert.Equal(t, "timeout", probeReason(context.DeadlineExceeded))
+	assert.Equal(t, "connect failed", probeReason(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.Equal(t, "connection reset", probeReason(io.EOF))
+	assert.Equal(t, "status 503", probeReason(errors.New("status 503")), "Expected the checker's message otherwise")
+}
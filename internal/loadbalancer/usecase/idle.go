@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleWatch calls onIdle once touch has not been called for timeout, for connections with traffic in both directions.
+type idleWatch struct {
+	timeout time.Duration
+	onIdle  func()
+	last    atomic.Int64 // unix nanoseconds
+	mux     sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newIdleWatch(timeout time.Duration, onIdle func()) *idleWatch {
+	w := &idleWatch{timeout: timeout, onIdle: onIdle}
+	w.touch()
+	w.mux.Lock()
+	w.timer = time.AfterFunc(timeout, w.check)
+	w.mux.Unlock()
+	return w
+}
+
+func (w *idleWatch) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+// check runs when the timer fires, and sets it again for the remainder if there was traffic meanwhile.
+func (w *idleWatch) check() {
+	idleFor := time.Since(time.Unix(0, w.last.Load()))
+	if idleFor >= w.timeout {
+		w.onIdle()
+		return
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if !w.stopped {
+		w.timer.Reset(w.timeout - idleFor)
+	}
+}
+
+func (w *idleWatch) stop() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.stopped = true
+	w.timer.Stop()
+}
+
+// activityReader touches an idleWatch whenever bytes are read.
+type activityReader struct {
+	io.Reader
+	watch *idleWatch
+}
+
+func (r activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.watch.touch()
+	}
+	return n, err
+}
@@ -2,171 +2,377 @@ package usecase
 
 import (
 	"GoRelay/internal/models"
+	"GoRelay/pkg/clientip"
 	"GoRelay/pkg/http_errors"
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"math"
+	"net"
 	"net/http"
-	"net/http/httptest"
 	"net/http/httputil"
-	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
 const (
 	RoundRobin       string = "roundrobin"
 	LeastConnections string = "leastconnections"
+	ConsistentHash   string = "hash"
+	PowerOfTwo       string = "p2c"
+	PeakEWMA         string = "peak_ewma"
 )
 
 type LoadBalancerUseCase struct {
-	Pool      *models.ServerPool
-	algorithm string
-	health    HealthChecker
-	proxy     *httputil.ReverseProxy
-	transport *http.Transport
+	Pool           *models.ServerPool
+	algorithm      string
+	health         HealthChecker
+	proxy          *httputil.ReverseProxy
+	transport      http.RoundTripper
+	flushInterval  time.Duration
+	retry          RetryPolicy
+	outlier        OutlierDetection
+	ejectMux       sync.Mutex
+	balancer       Balancer
+	hash           HashPolicy
+	affinity       *AffinityPolicy
+	ewmaDecay      time.Duration
+	trustedProxies *clientip.Resolver
+	headers        *HeaderRules
+	upgrade        UpgradePolicy
+	upgrades       *UpgradeTracker
+	healthPolicy   models.HealthPolicy
 }
 
-func NewLoadBalancerUseCase(pool *models.ServerPool, algorithm string, health HealthChecker, transport *http.Transport) *LoadBalancerUseCase {
+// Option customises a LoadBalancerUseCase at construction time.
+type Option func(*LoadBalancerUseCase)
+
+/*
+WithFlushInterval sets how often buffered response bytes are flushed to the client.
+Zero keeps the ReverseProxy default (streaming responses such as SSE are still flushed
+immediately), a negative value flushes after every write.
+*/
+func WithFlushInterval(interval time.Duration) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.flushInterval = interval
+	}
+}
+
+// WithHealthThresholds sets how many consecutive health checks must pass (rise) or fail (fall) to flip a backend, 1 when unset.
+func WithHealthThresholds(rise, fall int) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.healthPolicy.Rise, uc.healthPolicy.Fall = rise, fall
+	}
+}
+
+// WithFlapDetection holds a backend out of rotation for hold (window when zero) once it changes state transitions times within window. Zero transitions or window turns it off.
+func WithFlapDetection(window time.Duration, transitions int, hold time.Duration) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.healthPolicy.FlapWindow = window
+		uc.healthPolicy.FlapTransitions = transitions
+		uc.healthPolicy.FlapHold = hold
+	}
+}
+
+func NewLoadBalancerUseCase(pool *models.ServerPool, algorithm string, health HealthChecker, transport http.RoundTripper, opts ...Option) *LoadBalancerUseCase {
 	uc := &LoadBalancerUseCase{
 		Pool:      pool,
-		algorithm: strings.ToLower(algorithm),
+		algorithm: algorithm,
 		health:    health,
 		transport: transport,
+		retry:     DefaultRetryPolicy(),
+		outlier:   DefaultOutlierDetection(),
+		hash:      DefaultHashPolicy(),
+		ewmaDecay: DefaultEWMADecay,
+		upgrade:   DefaultUpgradePolicy(),
+		upgrades:  NewUpgradeTracker(),
+	}
+	for _, opt := range opts {
+		opt(uc)
+	}
+	if uc.balancer == nil {
+		balancer, err := NewBalancer(algorithm, BalancerOptions{Hash: uc.hash})
+		if err != nil {
+			// Config validation rejects unknown names, anything else gets the historical default
+			balancer, _ = NewBalancer(RoundRobin, BalancerOptions{})
+		}
+		uc.balancer = balancer
 	}
 	uc.proxy = &httputil.ReverseProxy{
-		/* Director: A ReverseProxy function that rewrites req.URL to route to a
-		backend selected by SelectBackend(). It’s low-level, called implicitly by ReverseProxy. */
+		/* Rewrite: A ReverseProxy function that points the outgoing request at the backend
+		selected by HandleRequest. It’s low-level, called implicitly by ReverseProxy. Unlike a Director it
+		gets a request with the incoming forwarding headers already stripped, so we decide what to keep. */
 
-		// Sets req.URL.Scheme and req.URL.Host to route to the chosen backend.
-		Director: func(req *http.Request) {
-			backend, ok := req.Context().Value("backend").(*models.Backend)
+		// Applies the route's path rewrite, then points pr.Out at the chosen backend, joining its base path.
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			backend, ok := pr.Out.Context().Value("backend").(*models.Backend)
 			if !ok || backend == nil {
 				// Fallback (shouldn’t happen with proper Proxy call)
 				return
 			}
-			req.URL.Scheme = backend.URL.Scheme
-			req.URL.Host = backend.URL.Host
-			req.Header.Set("X-Forwarded-For", req.RemoteAddr)
+			uc.dropRewriteHeaders(pr.In, pr.Out)
+			if route := RouteFromContext(pr.Out.Context()); route != nil && route.Rewrite != nil {
+				rewriteRequest(pr.Out, route.Rewrite)
+			}
+			targetURL(pr.Out, backend.URL)
+			uc.setForwardedHeaders(pr.In, pr.Out)
+			if rules := uc.headerRules(pr.Out.Context()); len(rules) > 0 {
+				vars := headerVars(pr.Out, backend)
+				for _, r := range rules {
+					r.Request.apply(pr.Out.Header, vars)
+				}
+			}
+		},
+		// Rejecting the response here, before anything is written, keeps the request retryable.
+		ModifyResponse: func(resp *http.Response) error {
+			at, _ := resp.Request.Context().Value(attemptKey{}).(*attempt)
+			if at == nil {
+				return nil
+			}
+			if at.timer != nil {
+				at.timer.Stop()
+			}
+			if at.backend != nil {
+				at.backend.ObserveLatency(time.Since(at.start), uc.ewmaDecay)
+			}
+			canRetry := !at.last && (at.replayable == nil || at.replayable())
+			if canRetry && !at.grpcOnly && at.policy.retryableStatus(resp.StatusCode) {
+				return &upstreamStatusError{code: resp.StatusCode}
+			}
+			// A trailers-only gRPC response carries its error in the headers, later ones can't be retried anyway
+			if code, ok := grpcStatus(resp.Header); canRetry && ok && isGRPC(resp.Request) && at.policy.retryableGRPC(code) {
+				return &upstreamStatusError{code: grpcHTTPStatus(code), grpc: true, grpcCode: code}
+			}
+			if rules := uc.headerRules(resp.Request.Context()); len(rules) > 0 {
+				vars := headerVars(resp.Request, at.backend)
+				for _, r := range rules {
+					r.Response.apply(resp.Header, vars)
+				}
+			}
+			if at.affinityCookie != nil {
+				resp.Header.Add("Set-Cookie", at.affinityCookie.String())
+			}
+			return nil
 		},
+		// Errors are handed back to HandleRequest, which decides between a retry and a 502.
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var status *upstreamStatusError
+			if at, _ := r.Context().Value(attemptKey{}).(*attempt); at != nil && at.backend != nil &&
+				!errors.As(err, &status) && context.Cause(r.Context()) != context.Canceled {
+				// A refused connection fails in microseconds: count failures as slow answers so peak-EWMA steers away
+				at.backend.ObserveLatency(max(time.Since(at.start), ewmaFailurePenalty), uc.ewmaDecay)
+			}
+			if pw, ok := w.(*proxyWriter); ok {
+				pw.err = err
+				return
+			}
 			w.WriteHeader(http.StatusBadGateway)
 		},
-		Transport: transport,
+		Transport:     transport,
+		FlushInterval: uc.flushInterval,
 	}
 	return uc
 }
 
-type statusRecorder struct {
-	statusCode int
-	http.ResponseWriter
+/* attempt carries per-try state through the request context into the ReverseProxy hooks */
+type attempt struct {
+	backend        *models.Backend
+	start          time.Time
+	policy         RetryPolicy
+	last           bool
+	timer          *time.Timer
+	affinityCookie *http.Cookie
+	replayable     func() bool // nil when the request body is known to be replayable
+	grpcOnly       bool        // only trailers-only gRPC statuses may be retried, see unprocessed
 }
 
-func (r *statusRecorder) WriteHeader(code int) {
-	r.statusCode = code
-	r.ResponseWriter.WriteHeader(code)
-}
+type attemptKey struct{}
 
-/*Proxy Forwards the request to director via ServeHTTP and tracks the response using recorder */
+/*Proxy streams the request to the backend via ServeHTTP and reports whether the attempt failed */
 func (uc *LoadBalancerUseCase) Proxy(req *http.Request, w http.ResponseWriter, backend *models.Backend) error {
+	return uc.proxyAttempt(req, w, backend, &attempt{policy: uc.retry, last: true})
+}
+
+func (uc *LoadBalancerUseCase) proxyAttempt(req *http.Request, w http.ResponseWriter, backend *models.Backend, at *attempt) error {
 	if backend == nil {
 		return http_errors.ErrNoHealthyBackend
 	}
+	at.backend, at.start = backend, time.Now()
 	ctx := context.WithValue(req.Context(), "backend", backend)
-	*req = *req.WithContext(ctx)
-	recorder := &statusRecorder{
-		ResponseWriter: w,
-		statusCode:     http.StatusOK,
+	ctx = context.WithValue(ctx, attemptKey{}, at)
+	if at.policy.PerAttemptTimeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		// Only the wait for response headers is bounded, ModifyResponse stops the timer so streamed bodies can take their time
+		at.timer = time.AfterFunc(at.policy.PerAttemptTimeout, func() { cancel(errAttemptTimeout) })
+		defer at.timer.Stop()
 	}
-
-	uc.proxy.ServeHTTP(recorder, req)
-	if recorder.statusCode < 200 || recorder.statusCode >= 300 {
-		return fmt.Errorf("backend returned non-2xx status: %d", recorder.statusCode)
-	}
-	return nil
-}
-
-func (uc *LoadBalancerUseCase) roundRobinSelection() *models.Backend {
-	healthyBackends := uc.Pool.GetBackends()
-	if len(healthyBackends) == 0 {
-		return nil
+	pw := newProxyWriter(w)
+	pw.err = nil
+	// A WebSocket or other upgrade keeps the backend counted as active until the connection closes, ServeHTTP returns only then
+	pw.onHijack = func(conn net.Conn, brw *bufio.ReadWriter) (net.Conn, error) {
+		return uc.upgrades.track(conn, brw.Reader, backend, uc.upgrade)
 	}
-	index := atomic.LoadUint64(&uc.Pool.CurrentIndex)
-	atomic.AddUint64(&uc.Pool.CurrentIndex, 1)
-	return healthyBackends[index%uint64(len(healthyBackends))]
-}
 
-func (uc *LoadBalancerUseCase) leastConnsSelection() *models.Backend {
-	min := math.MaxInt64
-	var chosen *models.Backend
-	for _, b := range uc.Pool.Backends {
-		if b.IsAlive() && b.GetActiveConnections() < min {
-			min = b.GetActiveConnections()
-			chosen = b
+	uc.proxy.ServeHTTP(pw, req.WithContext(ctx))
+	if pw.err != nil {
+		if errors.Is(context.Cause(ctx), errAttemptTimeout) {
+			return fmt.Errorf("%w: %w", errAttemptTimeout, pw.err)
 		}
+		return pw.err
 	}
-	return chosen
+	return nil
 }
 
 func (uc *LoadBalancerUseCase) SelectBackend() *models.Backend {
-	switch strings.ToLower(uc.algorithm) {
-	case RoundRobin:
-		return uc.roundRobinSelection()
-	case LeastConnections:
-		return uc.leastConnsSelection()
-	default:
-		return uc.roundRobinSelection()
-	}
+	return uc.SelectBackendForRequest(nil)
+}
+
+// SelectBackendForRequest is SelectBackend for strategies that look at the request, such as consistent hashing.
+func (uc *LoadBalancerUseCase) SelectBackendForRequest(req *http.Request) *models.Backend {
+	return uc.balancer.Select(uc.Pool, newRequestContext(req, nil))
 }
 
 /*
 	HandleRequest is the use case’s main method for processing requests, called by the HTTP handler.
 
-It manages retries, connection tracking, and passive health checks, invoking Proxy to forward requests via the single ReverseProxy
+It manages retries, connection tracking, and passive health checks, invoking Proxy to forward requests via the single ReverseProxy.
+Responses are streamed straight to the client, so a failed attempt is only retried while nothing has been written yet.
 */
 func (uc *LoadBalancerUseCase) HandleRequest(req *http.Request, w http.ResponseWriter) error {
-	for range 3 { // Retry up to 3 times
-		backend := uc.SelectBackend()
+	pw := newProxyWriter(w)
+	req = withRequestID(req)
+	policy := uc.policyFor(req)
+	body, replayable, err := prepareReplay(req, policy)
+	if err != nil {
+		pw.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	var tee *teeBody
+	// A gRPC call that isn't idempotent may only be retried when the backend can't have processed it
+	unsafeGRPC := false
+	if !replayable && isGRPC(req) {
+		// gRPC bodies are streams of unknown length: record them on the fly instead of up front
+		if req.Body != nil && req.Body != http.NoBody {
+			tee = newTeeBody(req, policy.MaxBodyBytes)
+		}
+		replayable = true
+		unsafeGRPC = !isIdempotent(req) && !policy.AllowNonIdempotent
+	}
+	attempts := max(policy.MaxAttempts, 1)
+	if !replayable {
+		attempts = 1
+	}
+	var pinned *models.Backend
+	if uc.affinity != nil {
+		pinned = uc.affinity.pinned(req, uc.Pool)
+	}
+	tried := make(map[*models.Backend]bool, attempts)
+	var lastErr error
+	for i := range attempts {
+		if i > 0 && tee != nil {
+			replay, ok := tee.replay()
+			if !ok {
+				break
+			}
+			if replay != nil {
+				body, tee = replay, nil
+			}
+		}
+		if i > 0 {
+			if err := sleepContext(req.Context(), policy.backoff(i)); err != nil {
+				return err
+			}
+		}
+		backend := pinned
+		if i > 0 || backend == nil {
+			backend = uc.selectUntried(req, tried, policy.DifferentBackend)
+		}
 		if backend == nil {
-			w.WriteHeader(http.StatusBadGateway)
-			return http_errors.ErrNoHealthyBackend
+			break
 		}
-		fmt.Println(backend.URL)
-		backend.IncrementConnections()
+		tried[backend] = true
+		if body != nil {
+			body.rewind(req)
+		}
+		last := i == attempts-1 || (policy.DifferentBackend && !uc.hasUntried(tried))
 
-		// Use a new ResponseRecorder for each retry
-		tempRecorder := httptest.NewRecorder()
-		err := uc.Proxy(req, tempRecorder, backend)
+		at := &attempt{policy: policy, last: last, grpcOnly: unsafeGRPC}
+		if tee != nil {
+			at.replayable = tee.replayable
+		}
+		if uc.affinity != nil && backend != pinned {
+			// New client, or its pinned backend is gone: (re)write the cookie for whoever answers
+			at.affinityCookie = uc.affinity.cookie(backend)
+		}
+
+		backend.IncrementConnections()
+		err := uc.proxyAttempt(req, pw, backend, at)
 		backend.DecrementConnections()
+		if req.Context().Err() != nil {
+			// The client is gone, this says nothing about the backend
+			return req.Context().Err()
+		}
+		uc.recordOutcome(backend, responseStatus(pw), err)
 		if err == nil {
-			// Copy successful response to original ResponseWriter
-			w.WriteHeader(tempRecorder.Code)
-			io.Copy(w, tempRecorder.Body)
 			return nil
 		}
-		backend.SetAlive(false)
+		lastErr = err
+		if pw.Committed() {
+			// Part of the response already reached the client, a retry can't help
+			return err
+		}
+		if !policy.retryableError(err) || (unsafeGRPC && !unprocessed(err)) {
+			break
+		}
+	}
+	if lastErr == nil {
+		writeGatewayError(pw, req, http.StatusBadGateway)
+		return http_errors.ErrNoHealthyBackend
+	}
+	if classifyError(lastErr) == RetryOnTimeout {
+		writeGatewayError(pw, req, http.StatusGatewayTimeout)
+	} else {
+		writeGatewayError(pw, req, http.StatusBadGateway)
 	}
-	w.WriteHeader(http.StatusBadGateway)
-	return http_errors.ErrNoHealthyBackend
+	return fmt.Errorf("%w: %w", http_errors.ErrUpstreamFailed, lastErr)
 }
 
-func (uc *LoadBalancerUseCase) StartHealthChecksWithContext(ctx context.Context, interval time.Duration) {
-	for _, b := range uc.Pool.Backends {
-		go func(backend *models.Backend) {
-			ticker := time.NewTicker(interval)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ticker.C:
-					alive := uc.health.CheckHealth(backend)
-					backend.SetAlive(alive)
-				case <-ctx.Done():
-					return
-				}
-			}
-		}(b)
+// selectUntried asks the balancer for a backend, skipping ones already tried when the policy wants a different backend on retry.
+func (uc *LoadBalancerUseCase) selectUntried(req *http.Request, tried map[*models.Backend]bool, different bool) *models.Backend {
+	if !different {
+		return uc.SelectBackendForRequest(req)
+	}
+	return uc.selectExcluding(newRequestContext(req, tried))
+}
+
+// hasUntried reports whether an available backend is left outside tried, i.e. whether selectUntried may still find one.
+func (uc *LoadBalancerUseCase) hasUntried(tried map[*models.Backend]bool) bool {
+	for _, b := range uc.Pool.GetBackends() {
+		if !tried[b] {
+			return true
+		}
+	}
+	return false
+}
+
+// selectExcluding asks the balancer until it picks a backend outside rc.Exclude, giving up after one try per backend.
+func (uc *LoadBalancerUseCase) selectExcluding(rc *RequestContext) *models.Backend {
+	for range uc.Pool.GetBackendCount() {
+		backend := uc.balancer.Select(uc.Pool, rc)
+		if backend == nil || !rc.Exclude[backend] {
+			return backend
+		}
 	}
+	return nil
+}
+
+// StartHealthChecksWithContext probes the pool on its own scheduler until ctx ends, see HealthScheduler.
+func (uc *LoadBalancerUseCase) StartHealthChecksWithContext(ctx context.Context, interval time.Duration) {
+	s := NewHealthScheduler()
+	s.Schedule("", uc, interval, 0)
+	s.Run(ctx)
 }
 
 func (uc *LoadBalancerUseCase) GetHealthyBackends() int {
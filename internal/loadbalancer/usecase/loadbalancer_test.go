@@ -5,9 +5,13 @@ import (
 	"GoRelay/internal/models"
 	"GoRelay/pkg/http_errors"
 	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -148,6 +152,24 @@ func TestSelectBackend(t *testing.T) {
 
 }
 
+// newTestUseCase builds a use case over that many placeholder backends at 10.0.x.y:8080, which are never dialled.
+func newTestUseCase(t *testing.T, algorithm string, backends int, opts ...Option) (*LoadBalancerUseCase, []*models.Backend) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	pool := models.NewServerPool()
+	var list []*models.Backend
+	for i := range backends {
+		b, err := models.NewBackend(fmt.Sprintf("http://10.0.%d.%d:8080", (i+1)/256, (i+1)%256))
+		if err != nil {
+			t.Fatalf("Failed to create backend: %v", err)
+		}
+		pool.AddBackend(b)
+		list = append(list, b)
+	}
+	return NewLoadBalancerUseCase(pool, algorithm, healthChecker, &http.Transport{}, opts...), list
+}
+
 // Helper to parse URL or fail test
 func mustParseURL(t *testing.T, rawURL string) *url.URL {
 	u, err := url.Parse(rawURL)
@@ -330,3 +352,351 @@ func TestHealthChecks(t *testing.T) {
 	assert.True(t, backend.IsAlive(), "Backend 5001 should be marked healthy")
 	assert.False(t, backend2.IsAlive(), "Backend 5003 should be marked unhealthy")
 }
+
+func TestHealthThresholds(t *testing.T) {
+	results := []bool{false, false, false, true, true}
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool {
+			if len(results) == 0 {
+				return true
+			}
+			passed := results[0]
+			results = results[1:]
+			return passed
+		},
+	}
+	backend, _ := models.NewBackend("http://localhost:5001")
+	pool := models.NewServerPool()
+	pool.AddBackend(backend)
+	uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithHealthThresholds(2, 3))
+
+	// Drive the checks by hand, one result per step
+	record := func(passed bool, policy models.HealthPolicy) {
+		backend.RecordProbe(models.ProbeResult{Time: time.Now(), Passed: passed}, policy)
+	}
+	step := func() bool {
+		record(healthChecker.CheckHealth(backend), uc.healthPolicy)
+		return backend.IsAlive()
+	}
+	assert.True(t, step(), "One failed check should not take the backend down")
+	assert.True(t, step())
+	assert.False(t, step(), "Expected the third failure in a row to take it down")
+	assert.False(t, step(), "One passing check should not bring it back")
+	assert.True(t, step(), "Expected the second pass in a row to bring it back")
+
+	// A streak broken by the other result starts over
+	policy := models.HealthPolicy{Rise: 2, Fall: 3}
+	record(false, policy)
+	record(false, policy)
+	record(true, policy)
+	record(false, policy)
+	assert.True(t, backend.IsAlive(), "Expected an interrupted failure streak to be reset")
+}
+
+func TestHealthHistory(t *testing.T) {
+	backend, _ := models.NewBackend("http://localhost:5001")
+	policy := models.HealthPolicy{Rise: 1, Fall: 2}
+	now := time.Now()
+	probe := func(passed bool, reason string) *models.Transition {
+		now = now.Add(time.Second)
+		return backend.RecordProbe(models.ProbeResult{Time: now, Passed: passed, Reason: reason}, policy)
+	}
+
+	assert.Nil(t, probe(false, "timeout"), "Expected no transition before the fall threshold")
+	down := probe(false, "status 503")
+	if assert.NotNil(t, down) {
+		assert.Equal(t, models.HealthUp, down.From)
+		assert.Equal(t, models.HealthDown, down.To)
+		assert.Equal(t, "status 503 (2 consecutive checks failed)", down.Reason)
+	}
+	assert.Equal(t, models.HealthDown, backend.HealthState())
+	up := probe(true, "")
+	if assert.NotNil(t, up) {
+		assert.Equal(t, models.HealthUp, up.To)
+		assert.Equal(t, "check passed", up.Reason)
+	}
+
+	probes, transitions := backend.HealthHistory()
+	assert.Len(t, probes, 3)
+	assert.Equal(t, "timeout", probes[0].Reason)
+	assert.Len(t, transitions, 2)
+
+	for range 2 * models.HealthHistorySize {
+		probe(true, "")
+	}
+	probes, _ = backend.HealthHistory()
+	assert.Len(t, probes, models.HealthHistorySize, "Expected the history to be bounded")
+	assert.Equal(t, now, probes[len(probes)-1].Time, "Expected the newest probe to be kept")
+}
+
+func TestFlapDetection(t *testing.T) {
+	backend, _ := models.NewBackend("http://localhost:5001")
+	policy := models.HealthPolicy{FlapWindow: time.Minute, FlapTransitions: 3, FlapHold: time.Hour}
+	now := time.Now()
+	probe := func(passed bool, after time.Duration) *models.Transition {
+		now = now.Add(after)
+		return backend.RecordProbe(models.ProbeResult{Time: now, Passed: passed, Reason: "body mismatch"}, policy)
+	}
+
+	assert.Equal(t, models.HealthDown, probe(false, time.Second).To)
+	assert.Equal(t, models.HealthUp, probe(true, time.Second).To)
+	flapping := probe(false, time.Second)
+	if assert.NotNil(t, flapping) {
+		assert.Equal(t, models.HealthFlapping, flapping.To)
+		assert.Equal(t, "3 state changes within 1m0s", flapping.Reason)
+	}
+	assert.Nil(t, probe(true, time.Second), "Expected passing checks not to end the hold")
+	assert.True(t, backend.IsAlive(), "Expected the probes to still be followed")
+	assert.False(t, backend.IsAvailable(), "Expected a flapping backend to be out of rotation")
+
+	back := probe(true, time.Hour)
+	if assert.NotNil(t, back) {
+		assert.Equal(t, models.HealthFlapping, back.From)
+		assert.Equal(t, models.HealthUp, back.To)
+		assert.Equal(t, "stopped flapping", back.Reason)
+	}
+
+	// Without a window nothing is counted, nor kept
+	backend, _ = models.NewBackend("http://localhost:5003")
+	policy = models.HealthPolicy{FlapTransitions: 2}
+	for i := range 6 {
+		assert.NotEqual(t, models.HealthFlapping, probe(i%2 == 1, time.Second).To)
+	}
+	policy = models.HealthPolicy{FlapWindow: time.Minute, FlapTransitions: 3, FlapHold: time.Hour}
+
+	// Changes spread wider than the window are not flapping
+	backend, _ = models.NewBackend("http://localhost:5002")
+	for i := range 6 {
+		assert.NotEqual(t, models.HealthFlapping, probe(i%2 == 1, time.Minute).To)
+	}
+}
+
+func TestHandleRequestStreaming(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+
+	t.Run("ForwardsHeadersAndTrailers", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", "yes")
+			w.Header().Set("Trailer", "X-Checksum")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("payload"))
+			w.Header().Set("X-Checksum", "abc123")
+		}))
+		defer upstream.Close()
+
+		backend, _ := models.NewBackend(upstream.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(backend)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		err := uc.HandleRequest(req, w)
+
+		assert.NoError(t, err, "Expected no error")
+		resp := w.Result()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Unexpected status code")
+		assert.Equal(t, "yes", resp.Header.Get("X-Upstream"), "Upstream header should be forwarded")
+		assert.Equal(t, "payload", w.Body.String(), "Unexpected response body")
+		assert.Equal(t, "abc123", resp.Trailer.Get("X-Checksum"), "Upstream trailer should be forwarded")
+	})
+
+	t.Run("FlushesEventsBeforeUpstreamFinishes", func(t *testing.T) {
+		release := make(chan struct{})
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+		defer upstream.Close()
+		defer close(release)
+
+		backend, _ := models.NewBackend(upstream.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(backend)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+		front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uc.HandleRequest(r, w)
+		}))
+		defer front.Close()
+
+		resp, err := http.Get(front.URL)
+		assert.NoError(t, err, "Expected no error")
+		defer resp.Body.Close()
+
+		buf := make([]byte, len("data: first\n\n"))
+		done := make(chan error, 1)
+		go func() {
+			_, err := io.ReadFull(resp.Body, buf)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err, "Expected first event to be readable")
+			assert.Equal(t, "data: first\n\n", string(buf), "Unexpected event")
+		case <-time.After(2 * time.Second):
+			t.Fatal("first event was not flushed while upstream was still streaming")
+		}
+	})
+
+	t.Run("RetriesFailureBeforeFirstByte", func(t *testing.T) {
+		broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}))
+		defer broken.Close()
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("from healthy"))
+		}))
+		defer healthy.Close()
+
+		b1, _ := models.NewBackend(broken.URL)
+		b2, _ := models.NewBackend(healthy.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(b1)
+		pool.AddBackend(b2)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		err := uc.HandleRequest(req, w)
+
+		assert.NoError(t, err, "Expected retry to succeed")
+		assert.Equal(t, http.StatusOK, w.Code, "Unexpected status code")
+		assert.Equal(t, "from healthy", w.Body.String(), "Unexpected response body")
+		assert.Equal(t, 0, b1.GetActiveConnections(), "Active connections should be zero after request")
+		assert.Equal(t, 0, b2.GetActiveConnections(), "Active connections should be zero after request")
+	})
+}
+
+func TestHandleRequestReplaysBody(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		policy        RetryPolicy
+		expectedCalls int
+		expectedCode  int
+	}{
+		{name: "PutIsRetriedWithBody", method: "PUT", policy: DefaultRetryPolicy(), expectedCalls: 2, expectedCode: http.StatusOK},
+		{name: "PostIsNotRetried", method: "POST", policy: DefaultRetryPolicy(), expectedCalls: 1, expectedCode: http.StatusServiceUnavailable},
+		{name: "PostRetriedWhenAllowed", method: "POST", policy: RetryPolicy{MaxAttempts: 3, RetryOnStatus: []int{503}, MaxBodyBytes: 1024, AllowNonIdempotent: true}, expectedCalls: 2, expectedCode: http.StatusOK},
+		{name: "BodyAboveLimitIsNotRetried", method: "PUT", policy: RetryPolicy{MaxAttempts: 3, RetryOnStatus: []int{503}, MaxBodyBytes: 4}, expectedCalls: 1, expectedCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var received []string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				data, _ := io.ReadAll(r.Body)
+				received = append(received, string(data))
+				if calls == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			b1, _ := models.NewBackend(upstream.URL)
+			b2, _ := models.NewBackend(upstream.URL)
+			pool := models.NewServerPool()
+			pool.AddBackend(b1)
+			pool.AddBackend(b2)
+			uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithRetryPolicy(tt.policy))
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader("payload"))
+			w := httptest.NewRecorder()
+			uc.HandleRequest(req, w)
+
+			assert.Equal(t, tt.expectedCalls, calls, "Unexpected number of upstream attempts")
+			assert.Equal(t, tt.expectedCode, w.Code, "Unexpected status code")
+			for _, body := range received {
+				assert.Equal(t, "payload", body, "Every attempt should carry the request body")
+			}
+		})
+	}
+}
+
+func TestWeightedSelection(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	newWeightedPool := func(weights ...int) (*models.ServerPool, []*models.Backend) {
+		pool := models.NewServerPool()
+		var backends []*models.Backend
+		for i, w := range weights {
+			b, _ := models.NewBackend("http://localhost:" + strconv.Itoa(5001+i))
+			b.SetWeight(w)
+			pool.AddBackend(b)
+			backends = append(backends, b)
+		}
+		return pool, backends
+	}
+
+	t.Run("SmoothRoundRobinRatios", func(t *testing.T) {
+		pool, backends := newWeightedPool(5, 1, 1)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+
+		var sequence []*models.Backend
+		counts := map[*models.Backend]int{}
+		for range 70 {
+			b := uc.SelectBackend()
+			sequence = append(sequence, b)
+			counts[b]++
+		}
+		assert.Equal(t, 50, counts[backends[0]], "Weight 5 backend should get 5/7 of the picks")
+		assert.Equal(t, 10, counts[backends[1]], "Weight 1 backend should get 1/7 of the picks")
+		assert.Equal(t, 10, counts[backends[2]], "Weight 1 backend should get 1/7 of the picks")
+
+		expected := []*models.Backend{backends[0], backends[0], backends[1], backends[0], backends[2], backends[0], backends[0]}
+		assert.Equal(t, expected, sequence[:7], "Picks should be interleaved, not bursty")
+	})
+
+	t.Run("SmoothRoundRobinSkipsUnhealthy", func(t *testing.T) {
+		pool, backends := newWeightedPool(3, 2, 1)
+		backends[0].SetAlive(false)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+
+		counts := map[*models.Backend]int{}
+		for range 30 {
+			counts[uc.SelectBackend()]++
+		}
+		assert.Equal(t, 0, counts[backends[0]], "Unhealthy backend should not be picked")
+		assert.Equal(t, 20, counts[backends[1]], "Weight 2 backend should get 2/3 of the picks")
+		assert.Equal(t, 10, counts[backends[2]], "Weight 1 backend should get 1/3 of the picks")
+	})
+
+	t.Run("SmoothRoundRobinForgetsRemovedBackends", func(t *testing.T) {
+		pool, backends := newWeightedPool(1, 1, 1)
+		rr := &roundRobinBalancer{}
+		for range 5 {
+			rr.Select(pool, nil)
+		}
+		pool.RemoveBackend(backends[1])
+		pool.RemoveBackend(backends[2])
+		assert.Same(t, backends[0], rr.Select(pool, nil))
+		assert.Len(t, rr.weights, 1, "Removed backends should not stay in the running totals")
+	})
+
+	t.Run("WeightedLeastConnections", func(t *testing.T) {
+		pool, backends := newWeightedPool(3, 1)
+		uc := NewLoadBalancerUseCase(pool, LeastConnections, healthChecker, &http.Transport{})
+
+		// Hold every picked connection open: load should settle at a 3:1 ratio
+		for range 40 {
+			uc.SelectBackend().IncrementConnections()
+		}
+		assert.Equal(t, 30, backends[0].GetActiveConnections(), "Weight 3 backend should hold 3/4 of the connections")
+		assert.Equal(t, 10, backends[1].GetActiveConnections(), "Weight 1 backend should hold 1/4 of the connections")
+	})
+}
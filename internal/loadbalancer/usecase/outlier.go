@@ -0,0 +1,202 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultConsecutive5xx           int           = 5
+	DefaultConsecutiveGateway       int           = 5
+	DefaultOutlierInterval          time.Duration = 10 * time.Second
+	DefaultBaseEjectionTime         time.Duration = 30 * time.Second
+	DefaultMaxEjectionTime          time.Duration = 300 * time.Second
+	DefaultMaxEjectionPercent       int           = 10
+	DefaultSuccessRateMinimumHosts  int           = 5
+	DefaultSuccessRateRequestVolume int           = 100
+	DefaultSuccessRateStdevFactor   float64       = 1.9
+)
+
+/*
+OutlierDetection configures passive health checking in the Envoy style: backends are ejected
+after a streak of 5xx or gateway errors, or when their success rate falls well below the rest of the pool.
+Each ejection lasts BaseEjectionTime times the number of times the backend has been ejected, capped at MaxEjectionTime.
+A zero threshold disables the corresponding check.
+*/
+type OutlierDetection struct {
+	Consecutive5xx           int
+	ConsecutiveGateway       int
+	Interval                 time.Duration
+	BaseEjectionTime         time.Duration
+	MaxEjectionTime          time.Duration
+	MaxEjectionPercent       int
+	SuccessRateMinimumHosts  int
+	SuccessRateRequestVolume int
+	SuccessRateStdevFactor   float64
+}
+
+func DefaultOutlierDetection() OutlierDetection {
+	return OutlierDetection{
+		Consecutive5xx:           DefaultConsecutive5xx,
+		ConsecutiveGateway:       DefaultConsecutiveGateway,
+		Interval:                 DefaultOutlierInterval,
+		BaseEjectionTime:         DefaultBaseEjectionTime,
+		MaxEjectionTime:          DefaultMaxEjectionTime,
+		MaxEjectionPercent:       DefaultMaxEjectionPercent,
+		SuccessRateMinimumHosts:  DefaultSuccessRateMinimumHosts,
+		SuccessRateRequestVolume: DefaultSuccessRateRequestVolume,
+		SuccessRateStdevFactor:   DefaultSuccessRateStdevFactor,
+	}
+}
+
+// OutlierDetectionFromConfig fills the fields left empty in cfg with the defaults.
+func OutlierDetectionFromConfig(cfg utils.OutlierDetectionConfig) OutlierDetection {
+	od := DefaultOutlierDetection()
+	if cfg.Disabled {
+		return OutlierDetection{}
+	}
+	if cfg.Consecutive5xx != nil {
+		od.Consecutive5xx = *cfg.Consecutive5xx
+	}
+	if cfg.ConsecutiveGatewayFailure != nil {
+		od.ConsecutiveGateway = *cfg.ConsecutiveGatewayFailure
+	}
+	if cfg.Interval > 0 {
+		od.Interval = cfg.Interval
+	}
+	if cfg.BaseEjectionTime > 0 {
+		od.BaseEjectionTime = cfg.BaseEjectionTime
+	}
+	if cfg.MaxEjectionTime > 0 {
+		od.MaxEjectionTime = cfg.MaxEjectionTime
+	}
+	if cfg.MaxEjectionPercent != nil {
+		od.MaxEjectionPercent = *cfg.MaxEjectionPercent
+	}
+	if cfg.SuccessRateMinimumHosts > 0 {
+		od.SuccessRateMinimumHosts = cfg.SuccessRateMinimumHosts
+	}
+	if cfg.SuccessRateRequestVolume > 0 {
+		od.SuccessRateRequestVolume = cfg.SuccessRateRequestVolume
+	}
+	if cfg.SuccessRateStdevFactor > 0 {
+		od.SuccessRateStdevFactor = cfg.SuccessRateStdevFactor
+	}
+	return od
+}
+
+func WithOutlierDetection(od OutlierDetection) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.outlier = od
+	}
+}
+
+// recordOutcome feeds the result of one attempt into the backend's outlier counters and ejects it when a streak threshold is hit.
+func (uc *LoadBalancerUseCase) recordOutcome(backend *models.Backend, status int, err error) {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.code
+	} else if err != nil {
+		// Locally originated failures (refused, reset, timeout) count as gateway errors
+		status = http.StatusBadGateway
+	}
+	serverError := status >= 500
+	gatewayError := status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	consecutive5xx, consecutiveGateway := backend.RecordOutcome(!serverError, serverError, gatewayError)
+
+	od := uc.outlier
+	if (od.Consecutive5xx > 0 && consecutive5xx >= od.Consecutive5xx) ||
+		(od.ConsecutiveGateway > 0 && consecutiveGateway >= od.ConsecutiveGateway) {
+		uc.eject(backend)
+	}
+}
+
+/*
+eject takes backend out of rotation unless that would push the pool over MaxEjectionPercent or leave it
+with no available backend. Both are counted over the backends in rotation, plus the ejected ones for the
+percentage, so backends the health checks took down don't make room for more ejections.
+*/
+func (uc *LoadBalancerUseCase) eject(backend *models.Backend) bool {
+	uc.ejectMux.Lock()
+	defer uc.ejectMux.Unlock()
+	if backend.IsEjected() {
+		return false
+	}
+	available := len(uc.Pool.GetBackends())
+	ejected := uc.Pool.GetEjectedCount()
+	// One backend may always be ejected as long as it is not the last one standing
+	allowed := max((available+ejected)*uc.outlier.MaxEjectionPercent/100, 1)
+	if ejected+1 > allowed || (backend.IsAvailable() && available <= 1) {
+		return false
+	}
+	period := uc.outlier.BaseEjectionTime * time.Duration(backend.EjectionCount()+1)
+	if uc.outlier.MaxEjectionTime > 0 && period > uc.outlier.MaxEjectionTime {
+		period = uc.outlier.MaxEjectionTime
+	}
+	backend.Eject(time.Now().Add(period))
+	return true
+}
+
+/*
+sweepOutliers runs once per interval: it ejects backends whose success rate is more than
+SuccessRateStdevFactor standard deviations below the pool mean and decays the ejection count of recovered ones.
+*/
+func (uc *LoadBalancerUseCase) sweepOutliers() {
+	type sample struct {
+		backend *models.Backend
+		rate    float64
+	}
+	var samples []sample
+	for _, b := range uc.Pool.AllBackends() {
+		requests, successes := b.TakeOutlierWindow()
+		if b.IsEjected() {
+			continue
+		}
+		b.DecayEjections()
+		if requests == 0 || requests < uint64(uc.outlier.SuccessRateRequestVolume) {
+			continue
+		}
+		samples = append(samples, sample{backend: b, rate: float64(successes) / float64(requests)})
+	}
+	if uc.outlier.SuccessRateStdevFactor <= 0 || len(samples) == 0 || len(samples) < uc.outlier.SuccessRateMinimumHosts {
+		return
+	}
+
+	var sum float64
+	for _, s := range samples {
+		sum += s.rate
+	}
+	mean := sum / float64(len(samples))
+	var variance float64
+	for _, s := range samples {
+		variance += (s.rate - mean) * (s.rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(samples)))
+	threshold := mean - uc.outlier.SuccessRateStdevFactor*stdev
+	for _, s := range samples {
+		if s.rate < threshold {
+			uc.eject(s.backend)
+		}
+	}
+}
+
+func (uc *LoadBalancerUseCase) StartOutlierDetectionWithContext(ctx context.Context) {
+	if uc.outlier.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(uc.outlier.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			uc.sweepOutliers()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
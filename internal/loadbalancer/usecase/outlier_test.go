@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutlierDetectionConsecutiveErrors(t *testing.T) {
+	od := DefaultOutlierDetection()
+	od.Consecutive5xx = 3
+	od.MaxEjectionPercent = 50
+
+	t.Run("ClientErrorsDoNotEject", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, RoundRobin, 4, WithOutlierDetection(od))
+		for range 10 {
+			uc.recordOutcome(backends[0], http.StatusNotFound, nil)
+		}
+		assert.False(t, backends[0].IsEjected(), "4xx responses should not eject a backend")
+	})
+
+	t.Run("StreakEjects", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, RoundRobin, 4, WithOutlierDetection(od))
+		uc.recordOutcome(backends[0], http.StatusInternalServerError, nil)
+		uc.recordOutcome(backends[0], http.StatusInternalServerError, nil)
+		assert.False(t, backends[0].IsEjected(), "Backend should stay in rotation below the threshold")
+
+		uc.recordOutcome(backends[0], 0, errors.New("connection refused"))
+		assert.True(t, backends[0].IsEjected(), "Backend should be ejected at the threshold")
+		assert.False(t, backends[0].IsAvailable(), "Ejected backend should not be available")
+		assert.Equal(t, 3, uc.GetHealthyBackends(), "Ejected backend should not count as healthy")
+	})
+
+	t.Run("SuccessResetsStreak", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, RoundRobin, 4, WithOutlierDetection(od))
+		uc.recordOutcome(backends[0], http.StatusInternalServerError, nil)
+		uc.recordOutcome(backends[0], http.StatusInternalServerError, nil)
+		uc.recordOutcome(backends[0], http.StatusOK, nil)
+		uc.recordOutcome(backends[0], http.StatusInternalServerError, nil)
+		assert.False(t, backends[0].IsEjected(), "A success should reset the streak")
+	})
+
+	t.Run("MaxEjectionPercentKeepsPoolServing", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, RoundRobin, 2, WithOutlierDetection(DefaultOutlierDetection()))
+		for _, b := range backends {
+			for range DefaultConsecutive5xx {
+				uc.recordOutcome(b, http.StatusServiceUnavailable, nil)
+			}
+		}
+		assert.Equal(t, 1, uc.Pool.GetEjectedCount(), "The last backend standing should never be ejected")
+		assert.Equal(t, 1, uc.GetHealthyBackends(), "One backend should remain in rotation")
+	})
+
+	t.Run("HealthCheckedDownCountsAgainstEjection", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, RoundRobin, 2, WithOutlierDetection(od))
+		backends[1].SetAlive(false)
+		assert.False(t, uc.eject(backends[0]), "The only available backend should never be ejected")
+		assert.Equal(t, 1, uc.GetHealthyBackends(), "The pool should keep serving")
+
+		uc, backends = newTestUseCase(t, RoundRobin, 10, WithOutlierDetection(od))
+		for _, b := range backends[:6] {
+			b.SetAlive(false)
+		}
+		assert.True(t, uc.eject(backends[6]))
+		assert.True(t, uc.eject(backends[7]))
+		assert.False(t, uc.eject(backends[8]), "MaxEjectionPercent should count the backends in rotation, not the whole pool")
+	})
+
+	t.Run("EjectionPeriodGrows", func(t *testing.T) {
+		grow := od
+		grow.BaseEjectionTime = 10 * time.Millisecond
+		uc, backends := newTestUseCase(t, RoundRobin, 4, WithOutlierDetection(grow))
+
+		assert.True(t, uc.eject(backends[0]), "First ejection should succeed")
+		time.Sleep(15 * time.Millisecond)
+		assert.False(t, backends[0].IsEjected(), "Backend should return after the base ejection time")
+
+		assert.True(t, uc.eject(backends[0]), "Second ejection should succeed")
+		time.Sleep(15 * time.Millisecond)
+		assert.True(t, backends[0].IsEjected(), "Second ejection should last twice as long")
+	})
+}
+
+func TestOutlierDetectionSuccessRate(t *testing.T) {
+	od := DefaultOutlierDetection()
+	od.SuccessRateMinimumHosts = 3
+	od.SuccessRateRequestVolume = 10
+	od.MaxEjectionPercent = 50
+	uc, backends := newTestUseCase(t, RoundRobin, 5, WithOutlierDetection(od))
+
+	for i, b := range backends {
+		for n := range 20 {
+			status := http.StatusOK
+			// backend 0 fails every other request, without ever building a 5xx streak
+			if i == 0 && n%2 == 0 {
+				status = http.StatusInternalServerError
+			}
+			uc.recordOutcome(b, status, nil)
+		}
+	}
+	uc.sweepOutliers()
+
+	assert.True(t, backends[0].IsEjected(), "Backend with a low success rate should be ejected")
+	for _, b := range backends[1:] {
+		assert.False(t, b.IsEjected(), "Backends with a normal success rate should stay")
+	}
+}
@@ -0,0 +1,109 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	DefaultEWMADecay time.Duration = 10 * time.Second
+
+	// The latency recorded for an attempt that failed without a response, when it took less than that
+	ewmaFailurePenalty = time.Second
+
+	// How many random draws sampleTwo makes before falling back to a scan of the healthy backends
+	p2cSampleTries = 8
+)
+
+// WithEWMADecay sets how fast the latency average forgets old samples, zero keeps DefaultEWMADecay.
+func WithEWMADecay(decay time.Duration) Option {
+	return func(uc *LoadBalancerUseCase) {
+		if decay > 0 {
+			uc.ewmaDecay = decay
+		}
+	}
+}
+
+/*
+sampleTwo draws two distinct available backends at random straight from the pool, so the cost of a pick
+does not grow with the pool size. Only when random draws keep hitting unavailable backends does it
+fall back to scanning the healthy ones. b is nil when a single backend is available.
+*/
+func sampleTwo(pool *models.ServerPool) (a, b *models.Backend) {
+	backends := pool.AllBackends()
+	if len(backends) == 0 {
+		return nil, nil
+	}
+	draw := func(avoid *models.Backend) *models.Backend {
+		for range p2cSampleTries {
+			c := backends[rand.IntN(len(backends))]
+			if c != avoid && c.IsAvailable() {
+				return c
+			}
+		}
+		return nil
+	}
+	a = draw(nil)
+	if a != nil {
+		if b = draw(a); b != nil {
+			return a, b
+		}
+	}
+
+	healthy := pool.GetBackends()
+	switch len(healthy) {
+	case 0:
+		return nil, nil
+	case 1:
+		return healthy[0], nil
+	}
+	i := rand.IntN(len(healthy))
+	j := rand.IntN(len(healthy) - 1)
+	if j >= i {
+		j++
+	}
+	return healthy[i], healthy[j]
+}
+
+// p2cBalancer picks the less loaded of two random backends, load being active connections per unit of weight.
+type p2cBalancer struct{}
+
+func (p2cBalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	return pickCheaper(pool, func(b *models.Backend) float64 {
+		return float64(b.GetActiveConnections()) / float64(b.GetWeight())
+	})
+}
+
+/*
+peakEWMABalancer is P2C over the expected wait on each backend: its peak-EWMA latency times the
+requests it already has in flight (plus this one), per unit of weight.
+*/
+type peakEWMABalancer struct{}
+
+func (peakEWMABalancer) Select(pool *models.ServerPool, rc *RequestContext) *models.Backend {
+	return pickCheaper(pool, func(b *models.Backend) float64 {
+		inflight := b.GetActiveConnections()
+		ewma := float64(b.LatencyEWMA())
+		if ewma == 0 {
+			// No response seen yet: worth a probe when idle, but don't pile requests onto an unknown backend
+			if inflight == 0 {
+				return 0
+			}
+			return math.MaxFloat64
+		}
+		return ewma * float64(inflight+1) / float64(b.GetWeight())
+	})
+}
+
+func pickCheaper(pool *models.ServerPool, cost func(*models.Backend) float64) *models.Backend {
+	a, b := sampleTwo(pool)
+	if b == nil {
+		return a
+	}
+	if cost(b) < cost(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveLatency(t *testing.T) {
+	b, _ := models.NewBackend("http://localhost:5001")
+	assert.Equal(t, time.Duration(0), b.LatencyEWMA(), "No samples yet")
+
+	b.ObserveLatency(10*time.Millisecond, time.Second)
+	assert.InDelta(t, 10*time.Millisecond, b.LatencyEWMA(), float64(time.Millisecond), "First sample sets the average")
+
+	b.ObserveLatency(100*time.Millisecond, time.Second)
+	assert.InDelta(t, 100*time.Millisecond, b.LatencyEWMA(), float64(time.Millisecond), "A slower sample replaces the average at once")
+
+	b.ObserveLatency(10*time.Millisecond, time.Second)
+	assert.Greater(t, b.LatencyEWMA(), 90*time.Millisecond, "A fast sample right after only nudges the average")
+
+	time.Sleep(20 * time.Millisecond)
+	b.ObserveLatency(10*time.Millisecond, time.Millisecond)
+	assert.Less(t, b.LatencyEWMA(), 11*time.Millisecond, "An old average decays towards the new sample")
+}
+
+func TestP2CSelection(t *testing.T) {
+	t.Run("PicksLessLoaded", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, PowerOfTwo, 2)
+		for range 10 {
+			backends[0].IncrementConnections()
+		}
+		for range 20 {
+			assert.Same(t, backends[1], uc.SelectBackend(), "The idle backend should win every comparison")
+		}
+	})
+
+	t.Run("SkipsUnavailable", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, PowerOfTwo, 300)
+		for _, b := range backends[1:] {
+			b.SetAlive(false)
+		}
+		for range 20 {
+			assert.Same(t, backends[0], uc.SelectBackend(), "The only available backend should be picked")
+		}
+	})
+
+	t.Run("NoneAvailable", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, PowerOfTwo, 3)
+		for _, b := range backends {
+			b.SetAlive(false)
+		}
+		assert.Nil(t, uc.SelectBackend(), "Expected no backend selected")
+	})
+
+	t.Run("SpreadsLoad", func(t *testing.T) {
+		uc, backends := newTestUseCase(t, PowerOfTwo, 4)
+		for range 400 {
+			uc.SelectBackend().IncrementConnections()
+		}
+		for _, b := range backends {
+			assert.InDelta(t, 100, b.GetActiveConnections(), 10, "Held connections should spread evenly")
+		}
+	})
+}
+
+func TestPeakEWMASelection(t *testing.T) {
+	uc, backends := newTestUseCase(t, PeakEWMA, 3)
+	backends[0].ObserveLatency(2*time.Millisecond, time.Minute)
+	backends[1].ObserveLatency(3*time.Millisecond, time.Minute)
+	backends[2].ObserveLatency(80*time.Millisecond, time.Minute)
+
+	counts := map[*models.Backend]int{}
+	for range 200 {
+		counts[uc.SelectBackend()]++
+	}
+	assert.Equal(t, 0, counts[backends[2]], "The slow backend should lose every comparison")
+	assert.Greater(t, counts[backends[0]], 0, "Fast backends should share the traffic")
+	assert.Greater(t, counts[backends[1]], 0, "Fast backends should share the traffic")
+
+	t.Run("InflightRaisesCost", func(t *testing.T) {
+		for range 50 {
+			backends[0].IncrementConnections()
+		}
+		fast, slow := backends[0], backends[2]
+		backends[1].SetAlive(false)
+		// 2ms * 51 in flight is worse than 80ms * 1
+		assert.Same(t, slow, uc.SelectBackend(), "A saturated fast backend should lose to an idle slow one")
+		assert.NotSame(t, fast, uc.SelectBackend(), "A saturated fast backend should lose to an idle slow one")
+	})
+}
+
+func TestProxyRecordsLatency(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	uc, backends := newTestUseCase(t, PeakEWMA, 1)
+	backends[0].URL, _ = backends[0].URL.Parse(upstream.URL)
+
+	w := httptest.NewRecorder()
+	err := uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+	assert.NoError(t, err, "Expected no error")
+	assert.GreaterOrEqual(t, backends[0].LatencyEWMA(), 20*time.Millisecond, "Proxied response time should feed the EWMA")
+}
+
+func TestProxyPenalisesFailures(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	uc, backends := newTestUseCase(t, PeakEWMA, 1)
+	backends[0].URL, _ = backends[0].URL.Parse(upstream.URL)
+	backends[0].ObserveLatency(2*time.Millisecond, time.Minute)
+
+	err := uc.HandleRequest(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+
+	assert.Error(t, err, "Expected the refused connection to fail the request")
+	assert.Greater(t, backends[0].LatencyEWMA(), ewmaFailurePenalty/2, "A failing backend should stop looking fast")
+}
+
+func TestPeakEWMARecovers(t *testing.T) {
+	uc, backends := newTestUseCase(t, PeakEWMA, 2)
+	backends[0].ObserveLatency(ewmaFailurePenalty, 10*time.Millisecond)
+	backends[1].ObserveLatency(5*time.Millisecond, time.Minute)
+	assert.Same(t, backends[1], uc.SelectBackend(), "The penalised backend should lose at first")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Less(t, backends[0].LatencyEWMA(), time.Millisecond, "The penalty should decay without new samples")
+	assert.Greater(t, backends[0].LatencyEWMA(), time.Duration(0), "A decayed average still counts as observed")
+	assert.Same(t, backends[0], uc.SelectBackend(), "The penalised backend should be probed again once its penalty decayed")
+}
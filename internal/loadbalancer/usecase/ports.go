@@ -10,6 +10,11 @@ type HealthChecker interface {
 	CheckHealth(backend *models.Backend) bool
 }
 
+// HealthProber is a HealthChecker that can also tell why a check failed, nil meaning it passed.
+type HealthProber interface {
+	Probe(backend *models.Backend) error
+}
+
 type ConfigLoader interface {
 	Load() (*utils.Config, error)
 }
@@ -0,0 +1,84 @@
+package usecase
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+/*
+proxyWriter wraps the client ResponseWriter for the lifetime of a request.
+It remembers whether the status line has gone out, so HandleRequest knows if an attempt
+can still be retried, and collects the error the ReverseProxy reports instead of writing a 502 straight away.
+*/
+type proxyWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	err         error
+	// onHijack wraps the client connection when the ReverseProxy takes it over for an upgraded protocol
+	onHijack func(net.Conn, *bufio.ReadWriter) (net.Conn, error)
+}
+
+func newProxyWriter(w http.ResponseWriter) *proxyWriter {
+	if pw, ok := w.(*proxyWriter); ok {
+		return pw
+	}
+	return &proxyWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+	}
+}
+
+func (w *proxyWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	// Informational responses (103 Early Hints etc.) may precede the final status
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.statusCode = code
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *proxyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *proxyWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (hijacking, deadlines).
+func (w *proxyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack hands the client connection to an upgraded protocol; from here on the response counts as sent.
+func (w *proxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.statusCode, w.wroteHeader = http.StatusSwitchingProtocols, true
+	if w.onHijack != nil {
+		if conn, err = w.onHijack(conn, brw); err != nil {
+			return nil, nil, err
+		}
+	}
+	return conn, brw, nil
+}
+
+// Committed reports whether the response status has already been sent to the client.
+func (w *proxyWriter) Committed() bool {
+	return w.wroteHeader
+}
@@ -0,0 +1,184 @@
+package usecase
+
+import (
+	"GoRelay/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// Transport failure classes a RetryPolicy can opt into.
+const (
+	RetryOnConnectFailure string = "connect-failure"
+	RetryOnReset          string = "reset"
+	RetryOnTimeout        string = "timeout"
+)
+
+const (
+	DefaultMaxAttempts       int           = 3
+	DefaultBackoffBase       time.Duration = 25 * time.Millisecond
+	DefaultBackoffMax        time.Duration = 250 * time.Millisecond
+	DefaultMaxRetryBodyBytes int64         = 1 << 20
+)
+
+var errAttemptTimeout = errors.New("per-attempt timeout exceeded")
+
+// RetryPolicy controls when HandleRequest may send a request to the backends again.
+type RetryPolicy struct {
+	MaxAttempts        int
+	RetryOnStatus      []int
+	RetryOn            []string
+	PerAttemptTimeout  time.Duration
+	BackoffBase        time.Duration
+	BackoffMax         time.Duration
+	DifferentBackend   bool
+	MaxBodyBytes       int64
+	AllowNonIdempotent bool
+	RetryOnGRPC        []string
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   DefaultMaxAttempts,
+		RetryOnStatus: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		RetryOn:       []string{RetryOnConnectFailure, RetryOnReset, RetryOnTimeout},
+		BackoffBase:   DefaultBackoffBase,
+		BackoffMax:    DefaultBackoffMax,
+		MaxBodyBytes:  DefaultMaxRetryBodyBytes,
+		RetryOnGRPC:   []string{"unavailable"},
+	}
+}
+
+// RetryPolicyFromConfig fills the fields left empty in cfg with the defaults.
+func RetryPolicyFromConfig(cfg utils.RetryConfig) RetryPolicy {
+	return DefaultRetryPolicy().override(cfg)
+}
+
+// override returns p with the fields set in cfg replaced, as a route's retry block does to its pool's policy.
+func (p RetryPolicy) override(cfg utils.RetryConfig) RetryPolicy {
+	if cfg.MaxAttempts > 0 {
+		p.MaxAttempts = cfg.MaxAttempts
+	}
+	if len(cfg.RetryOnStatus) > 0 {
+		p.RetryOnStatus = cfg.RetryOnStatus
+	}
+	if len(cfg.RetryOn) > 0 {
+		p.RetryOn = cfg.RetryOn
+	}
+	if cfg.BackoffBase > 0 {
+		p.BackoffBase = cfg.BackoffBase
+	}
+	if cfg.BackoffMax > 0 {
+		p.BackoffMax = cfg.BackoffMax
+	}
+	if cfg.MaxBodyBytes > 0 {
+		p.MaxBodyBytes = cfg.MaxBodyBytes
+	}
+	if cfg.PerAttemptTimeout > 0 {
+		p.PerAttemptTimeout = cfg.PerAttemptTimeout
+	}
+	if cfg.DifferentBackend != nil {
+		p.DifferentBackend = *cfg.DifferentBackend
+	}
+	if cfg.AllowNonIdempotent != nil {
+		p.AllowNonIdempotent = *cfg.AllowNonIdempotent
+	}
+	if len(cfg.RetryOnGRPC) > 0 {
+		p.RetryOnGRPC = cfg.RetryOnGRPC
+	}
+	return p
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.retry = policy
+	}
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	return slices.Contains(p.RetryOnStatus, code)
+}
+
+// retryableError reports whether a failed attempt falls into one of the classes in RetryOn.
+func (p RetryPolicy) retryableError(err error) bool {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.grpc {
+			return p.retryableGRPC(statusErr.grpcCode)
+		}
+		return p.retryableStatus(statusErr.code)
+	}
+	class := classifyError(err)
+	return class != "" && slices.Contains(p.RetryOn, class)
+}
+
+/* backoff returns the delay before the given retry (1 for the first retry): exponential growth capped at BackoffMax, with full jitter */
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.BackoffBase <= 0 {
+		return 0
+	}
+	limit := p.BackoffBase << (retry - 1)
+	if limit <= 0 || (p.BackoffMax > 0 && limit > p.BackoffMax) {
+		limit = p.BackoffMax
+	}
+	return rand.N(limit + 1)
+}
+
+func classifyError(err error) string {
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errAttemptTimeout), errors.Is(err, context.DeadlineExceeded):
+		return RetryOnTimeout
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return RetryOnConnectFailure
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return RetryOnReset
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return RetryOnTimeout
+	}
+	return ""
+}
+
+// upstreamStatusError rejects a response whose status the policy wants retried. For gRPC, code is the HTTP equivalent of grpcCode.
+type upstreamStatusError struct {
+	code     int
+	grpc     bool
+	grpcCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	if e.grpc {
+		return fmt.Sprintf("backend returned retryable grpc-status: %d", e.grpcCode)
+	}
+	return fmt.Sprintf("backend returned retryable status: %d", e.code)
+}
+
+// policyFor returns the pool-wide retry policy with the overrides of the route the request matched, if any.
+func (uc *LoadBalancerUseCase) policyFor(req *http.Request) RetryPolicy {
+	if route := RouteFromContext(req.Context()); route != nil && route.Retry != nil {
+		return uc.retry.override(*route.Retry)
+	}
+	return uc.retry
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
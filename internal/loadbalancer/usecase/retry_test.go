@@ -0,0 +1,204 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+
+	newUseCase := func(handler http.HandlerFunc, backends int, opts ...Option) (*LoadBalancerUseCase, *httptest.Server) {
+		upstream := httptest.NewServer(handler)
+		pool := models.NewServerPool()
+		for range backends {
+			b, _ := models.NewBackend(upstream.URL)
+			pool.AddBackend(b)
+		}
+		return NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, opts...), upstream
+	}
+
+	t.Run("ClientErrorIsNotRetried", func(t *testing.T) {
+		var calls atomic.Int32
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("missing"))
+		}, 3)
+		defer upstream.Close()
+
+		w := httptest.NewRecorder()
+		err := uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+		assert.NoError(t, err, "A 404 is a valid answer, not a failure")
+		assert.Equal(t, int32(1), calls.Load(), "Expected a single attempt")
+		assert.Equal(t, http.StatusNotFound, w.Code, "Upstream status should reach the client")
+		assert.Equal(t, "missing", w.Body.String(), "Upstream body should reach the client")
+	})
+
+	t.Run("LastRetryableStatusIsPassedThrough", func(t *testing.T) {
+		var calls atomic.Int32
+		policy := DefaultRetryPolicy()
+		policy.MaxAttempts = 2
+		policy.BackoffBase = 0
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, 3, WithRetryPolicy(policy))
+		defer upstream.Close()
+
+		w := httptest.NewRecorder()
+		uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+		assert.Equal(t, int32(2), calls.Load(), "Expected MaxAttempts attempts")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Final upstream status should reach the client")
+	})
+
+	t.Run("EjectedBackendsDoNotEndRetriesEarly", func(t *testing.T) {
+		policy := DefaultRetryPolicy()
+		policy.MaxAttempts = 3
+		policy.BackoffBase = 0
+		policy.DifferentBackend = true
+		pool := models.NewServerPool()
+		var calls atomic.Int32
+		for range 3 {
+			var backend *models.Backend
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				// Like passive health checks taking the backend out while its request is in flight
+				backend.SetAlive(false)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer upstream.Close()
+			backend, _ = models.NewBackend(upstream.URL)
+			pool.AddBackend(backend)
+		}
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithRetryPolicy(policy))
+
+		w := httptest.NewRecorder()
+		uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+		assert.Equal(t, int32(3), calls.Load(), "Expected the untried backend to get the last attempt")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Final upstream status should reach the client")
+	})
+
+	t.Run("PerAttemptTimeout", func(t *testing.T) {
+		var calls atomic.Int32
+		policy := DefaultRetryPolicy()
+		policy.PerAttemptTimeout = 50 * time.Millisecond
+		policy.MaxAttempts = 2
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}, 2, WithRetryPolicy(policy))
+		defer upstream.Close()
+
+		w := httptest.NewRecorder()
+		err := uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+		assert.ErrorIs(t, err, http_errors.ErrUpstreamFailed, "Expected attempts to be exhausted")
+		assert.ErrorIs(t, err, errAttemptTimeout, "Expected the timeout to be reported")
+		assert.Equal(t, int32(2), calls.Load(), "Timed out attempts should be retried")
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code, "Expected 504 after timeouts")
+	})
+
+	t.Run("DifferentBackendStopsWhenPoolIsExhausted", func(t *testing.T) {
+		var calls atomic.Int32
+		policy := DefaultRetryPolicy()
+		policy.MaxAttempts = 5
+		policy.BackoffBase = 0
+		policy.DifferentBackend = true
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, 2, WithRetryPolicy(policy))
+		defer upstream.Close()
+
+		w := httptest.NewRecorder()
+		uc.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+
+		assert.Equal(t, int32(2), calls.Load(), "Each backend should be tried once")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Final upstream status should reach the client")
+	})
+
+	t.Run("RouteOverridesPolicy", func(t *testing.T) {
+		var calls atomic.Int32
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, 3)
+		defer upstream.Close()
+		routes, err := RoutesFromConfig([]utils.RouteConfig{
+			{Name: "payments", PathPrefix: "/payments", Retry: &utils.RetryConfig{MaxAttempts: 1}},
+		})
+		assert.NoError(t, err, "Expected valid routes")
+		router, err := NewRouter(map[string]*LoadBalancerUseCase{utils.DefaultPool: uc}, routes)
+		assert.NoError(t, err, "Expected valid router")
+
+		w := httptest.NewRecorder()
+		router.HandleRequest(httptest.NewRequest("GET", "/payments/42", nil), w)
+		assert.Equal(t, int32(1), calls.Load(), "Route policy should allow a single attempt")
+
+		calls.Store(0)
+		w = httptest.NewRecorder()
+		router.HandleRequest(httptest.NewRequest("GET", "/other", nil), w)
+		assert.Equal(t, int32(3), calls.Load(), "Other paths should use the default policy")
+	})
+
+	t.Run("RouteInheritsPoolPolicy", func(t *testing.T) {
+		var calls atomic.Int32
+		policy := DefaultRetryPolicy()
+		policy.RetryOnStatus = []int{http.StatusInternalServerError}
+		policy.BackoffBase = 0
+		uc, upstream := newUseCase(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, 3, WithRetryPolicy(policy))
+		defer upstream.Close()
+		routes, _ := RoutesFromConfig([]utils.RouteConfig{
+			{Name: "reports", PathPrefix: "/reports", Retry: &utils.RetryConfig{MaxAttempts: 2}},
+		})
+		router, _ := NewRouter(map[string]*LoadBalancerUseCase{utils.DefaultPool: uc}, routes)
+
+		router.HandleRequest(httptest.NewRequest("GET", "/reports/1", nil), httptest.NewRecorder())
+		assert.Equal(t, int32(2), calls.Load(), "The route should keep the pool's retryable statuses")
+	})
+
+	t.Run("RouteBooleansOverrideOnlyWhenSet", func(t *testing.T) {
+		pool := RetryPolicy{DifferentBackend: true, AllowNonIdempotent: true, PerAttemptTimeout: time.Second}
+		inherited := pool.override(utils.RetryConfig{MaxAttempts: 1})
+		assert.True(t, inherited.DifferentBackend, "An unset differentBackend should inherit the pool's")
+		assert.True(t, inherited.AllowNonIdempotent, "An unset allowNonIdempotent should inherit the pool's")
+		assert.Equal(t, time.Second, inherited.PerAttemptTimeout, "An unset perAttemptTimeout should inherit the pool's")
+
+		off := false
+		overridden := pool.override(utils.RetryConfig{DifferentBackend: &off, AllowNonIdempotent: &off})
+		assert.False(t, overridden.DifferentBackend, "An explicit false should win over the pool's")
+		assert.False(t, overridden.AllowNonIdempotent, "An explicit false should win over the pool's")
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{BackoffBase: 10 * time.Millisecond, BackoffMax: 40 * time.Millisecond}
+	for retry, limit := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 10: 40 * time.Millisecond} {
+		for range 50 {
+			d := policy.backoff(retry)
+			assert.GreaterOrEqual(t, d, time.Duration(0), "Backoff should not be negative")
+			assert.LessOrEqual(t, d, limit, "Backoff should stay under the exponential cap")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	HeaderForwardedPrefix = "X-Forwarded-Prefix"
+	HeaderOriginalURI     = "X-Original-URI"
+)
+
+// PathRewrite changes the path of a routed request before it is joined with the backend's base path.
+type PathRewrite struct {
+	Prefix        string
+	StripPrefix   bool
+	ReplacePrefix string
+	Regex         *regexp.Regexp
+	Replacement   string
+}
+
+// PathRewriteFromConfig returns nil when the route doesn't rewrite anything.
+func PathRewriteFromConfig(prefix string, cfg utils.RewriteConfig) (*PathRewrite, error) {
+	if !cfg.StripPrefix && cfg.ReplacePrefix == "" && cfg.Regex == "" {
+		return nil, nil
+	}
+	rw := &PathRewrite{
+		Prefix:        prefix,
+		StripPrefix:   cfg.StripPrefix,
+		ReplacePrefix: cfg.ReplacePrefix,
+		Replacement:   cfg.Replacement,
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, err
+		}
+		rw.Regex = re
+	}
+	return rw, nil
+}
+
+// apply returns the rewritten path and the prefix the client saw in place of the removed part, if any.
+func (rw *PathRewrite) apply(path string) (string, string) {
+	var forwardedPrefix string
+	if rw.Prefix != "" && (rw.StripPrefix || rw.ReplacePrefix != "") {
+		if hasPathPrefix(path, rw.Prefix) {
+			rest := path[len(rw.Prefix):]
+			forwardedPrefix = strings.TrimSuffix(rw.Prefix, "/")
+			if rw.StripPrefix {
+				path = "/" + strings.TrimPrefix(rest, "/")
+			} else {
+				path = singleJoiningSlash(rw.ReplacePrefix, rest)
+			}
+		}
+	}
+	if rw.Regex != nil {
+		path = rw.Regex.ReplaceAllString(path, rw.Replacement)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	return path, forwardedPrefix
+}
+
+/*
+rewriteRequest applies the route's rewrite to an outgoing request, keeping the original URI in headers for
+the backend. It works on the escaped path, so an encoded slash stays data and never becomes a separator.
+*/
+func rewriteRequest(req *http.Request, rw *PathRewrite) {
+	original := req.URL.RequestURI()
+	escaped := req.URL.EscapedPath()
+	rewritten, prefix := rw.apply(escaped)
+	if rewritten == escaped {
+		return
+	}
+	path, err := url.PathUnescape(rewritten)
+	if err != nil {
+		// The replacement made an invalid escape, send it as it is
+		path = rewritten
+	}
+	req.URL.Path, req.URL.RawPath = path, rewritten
+	if req.URL.RawPath == (&url.URL{Path: path}).EscapedPath() {
+		req.URL.RawPath = ""
+	}
+	req.Header.Set(HeaderOriginalURI, original)
+	if prefix != "" {
+		req.Header.Set(HeaderForwardedPrefix, prefix)
+	}
+}
+
+// dropRewriteHeaders removes X-Forwarded-Prefix and X-Original-URI sent by anyone but a trusted proxy.
+func (uc *LoadBalancerUseCase) dropRewriteHeaders(in, out *http.Request) {
+	if uc.trustedProxies.Trusted(clientip.ParseAddr(in.RemoteAddr)) {
+		return
+	}
+	out.Header.Del(HeaderForwardedPrefix)
+	out.Header.Del(HeaderOriginalURI)
+}
+
+/*
+targetURL points req at backend: scheme and host are replaced, the backend's base path is prepended
+to the request path and its query (if any) merged in front of the request query.
+*/
+func targetURL(req *http.Request, backend *url.URL) {
+	req.URL.Scheme = backend.Scheme
+	req.URL.Host = backend.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(backend, req.URL)
+	if backend.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = backend.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = backend.RawQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && b != "":
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// joinURLPath joins the paths like net/http/httputil does, keeping RawPath consistent when either side is escaped.
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
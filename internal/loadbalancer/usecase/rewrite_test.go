@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathRewrite(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	type seen struct {
+		uri, prefix, original string
+	}
+	var got seen
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = seen{r.RequestURI, r.Header.Get(HeaderForwardedPrefix), r.Header.Get(HeaderOriginalURI)}
+	}))
+	defer upstream.Close()
+
+	newRouter := func(basePath string, cfg utils.RouteConfig) *Router {
+		b, _ := models.NewBackend(upstream.URL + basePath)
+		pool := models.NewServerPool()
+		pool.AddBackend(b)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+		routes, err := RoutesFromConfig([]utils.RouteConfig{cfg})
+		assert.NoError(t, err, "Expected valid routes")
+		router, err := NewRouter(map[string]*LoadBalancerUseCase{utils.DefaultPool: uc}, routes)
+		assert.NoError(t, err, "Expected valid router")
+		return router
+	}
+
+	tests := []struct {
+		name     string
+		basePath string
+		route    utils.RouteConfig
+		request  string
+		expected seen
+	}{
+		{
+			name:     "BasePathIsJoined",
+			basePath: "/api/v2",
+			route:    utils.RouteConfig{Name: "plain"},
+			request:  "/users?page=2",
+			expected: seen{uri: "/api/v2/users?page=2"},
+		},
+		{
+			name:     "BasePathWithTrailingSlash",
+			basePath: "/api/v2/",
+			route:    utils.RouteConfig{Name: "plain"},
+			request:  "/users",
+			expected: seen{uri: "/api/v2/users"},
+		},
+		{
+			name:     "StripPrefix",
+			route:    utils.RouteConfig{Name: "strip", PathPrefix: "/shop/", Rewrite: utils.RewriteConfig{StripPrefix: true}},
+			request:  "/shop/cart?id=1",
+			expected: seen{uri: "/cart?id=1", prefix: "/shop", original: "/shop/cart?id=1"},
+		},
+		{
+			name:     "StripPrefixOntoBasePath",
+			basePath: "/internal",
+			route:    utils.RouteConfig{Name: "strip", PathPrefix: "/shop", Rewrite: utils.RewriteConfig{StripPrefix: true}},
+			request:  "/shop",
+			expected: seen{uri: "/internal/", prefix: "/shop", original: "/shop"},
+		},
+		{
+			name:     "ReplacePrefix",
+			route:    utils.RouteConfig{Name: "replace", PathPrefix: "/v1/", Rewrite: utils.RewriteConfig{ReplacePrefix: "/legacy/"}},
+			request:  "/v1/orders/7",
+			expected: seen{uri: "/legacy/orders/7", prefix: "/v1", original: "/v1/orders/7"},
+		},
+		{
+			name:     "RegexRewrite",
+			route:    utils.RouteConfig{Name: "regex", Rewrite: utils.RewriteConfig{Regex: `^/users/(\d+)/profile$`, Replacement: "/profiles/$1"}},
+			request:  "/users/42/profile",
+			expected: seen{uri: "/profiles/42", original: "/users/42/profile"},
+		},
+		{
+			name:     "EncodedSlashStaysEncoded",
+			route:    utils.RouteConfig{Name: "strip", PathPrefix: "/shop/", Rewrite: utils.RewriteConfig{StripPrefix: true}},
+			request:  "/shop/a%2Fb",
+			expected: seen{uri: "/a%2Fb", prefix: "/shop", original: "/shop/a%2Fb"},
+		},
+		{
+			name:     "RegexOnEscapedPath",
+			route:    utils.RouteConfig{Name: "regex", Rewrite: utils.RewriteConfig{Regex: `^/files/([^/]+)$`, Replacement: "/blobs/$1"}},
+			request:  "/files/a%2Fb",
+			expected: seen{uri: "/blobs/a%2Fb", original: "/files/a%2Fb"},
+		},
+		{
+			name:     "RegexWithoutMatchLeavesPath",
+			route:    utils.RouteConfig{Name: "regex", Rewrite: utils.RewriteConfig{Regex: `^/users/(\d+)$`, Replacement: "/u/$1"}},
+			request:  "/users/me",
+			expected: seen{uri: "/users/me"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = seen{}
+			router := newRouter(tt.basePath, tt.route)
+			w := httptest.NewRecorder()
+			err := router.HandleRequest(httptest.NewRequest("GET", tt.request, nil), w)
+
+			assert.NoError(t, err, "Expected no error")
+			assert.Equal(t, tt.expected, got, "Unexpected upstream request")
+		})
+	}
+
+	t.Run("ClientCannotSetRewriteHeaders", func(t *testing.T) {
+		got = seen{}
+		router := newRouter("", utils.RouteConfig{Name: "plain"})
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set(HeaderForwardedPrefix, "/evil")
+		req.Header.Set(HeaderOriginalURI, "/evil/users")
+		router.HandleRequest(req, httptest.NewRecorder())
+		assert.Equal(t, seen{uri: "/users"}, got, "Expected the untrusted headers to be dropped")
+	})
+}
+
+func TestRewriteConfigValidation(t *testing.T) {
+	cfg := func(route utils.RouteConfig) *utils.Config {
+		return &utils.Config{
+			Port:           "8080",
+			HealthInterval: 1,
+			Algorithm:      "round_robin",
+			Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+			Routes:         []utils.RouteConfig{route},
+		}
+	}
+
+	assert.NoError(t, utils.ValidateConfig(cfg(utils.RouteConfig{PathPrefix: "/a", Rewrite: utils.RewriteConfig{StripPrefix: true}}), BalancerNames()), "Strip with a prefix should be valid")
+	assert.Error(t, utils.ValidateConfig(cfg(utils.RouteConfig{Rewrite: utils.RewriteConfig{StripPrefix: true}}), BalancerNames()), "Strip needs a path prefix")
+	assert.Error(t, utils.ValidateConfig(cfg(utils.RouteConfig{PathPrefix: "/a", Rewrite: utils.RewriteConfig{StripPrefix: true, ReplacePrefix: "/b"}}), BalancerNames()), "Strip and replace exclude each other")
+	assert.Error(t, utils.ValidateConfig(cfg(utils.RouteConfig{PathPrefix: "/a", Rewrite: utils.RewriteConfig{ReplacePrefix: "b"}}), BalancerNames()), "Replacement prefix must be absolute")
+	assert.Error(t, utils.ValidateConfig(cfg(utils.RouteConfig{Rewrite: utils.RewriteConfig{Regex: "(["}}), BalancerNames()), "Invalid rewrite regex should be rejected")
+}
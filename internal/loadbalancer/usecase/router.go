@@ -0,0 +1,176 @@
+package usecase
+
+import (
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/utils"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Route matches requests to a named pool and carries the settings that apply to them instead of the pool-wide ones.
+type Route struct {
+	Name        string
+	Host        string // exact, or "*.example.com" for any subdomain
+	PathPrefix  string
+	PathRegex   *regexp.Regexp
+	Methods     []string
+	Headers     map[string]string
+	Pool        string
+	Retry       *utils.RetryConfig // set fields override the pool's retry policy
+	Rewrite     *PathRewrite
+	HeaderRules *HeaderRules
+	ClientCert  *ClientCertPolicy
+}
+
+func RoutesFromConfig(cfgs []utils.RouteConfig) ([]Route, error) {
+	routes := make([]Route, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		route := Route{
+			Name:       cfg.Name,
+			Host:       strings.ToLower(cfg.Host),
+			PathPrefix: cfg.PathPrefix,
+			Headers:    cfg.Headers,
+			Pool:       cfg.Pool,
+		}
+		for _, m := range cfg.Methods {
+			route.Methods = append(route.Methods, strings.ToUpper(m))
+		}
+		if cfg.PathRegex != "" {
+			re, err := regexp.Compile(cfg.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: %w", cfg.Name, err)
+			}
+			route.PathRegex = re
+		}
+		if route.Pool == "" {
+			route.Pool = utils.DefaultPool
+		}
+		rewrite, err := PathRewriteFromConfig(cfg.PathPrefix, cfg.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", cfg.Name, err)
+		}
+		route.Rewrite = rewrite
+		headers, err := HeaderRulesFromConfig(cfg.HeaderRules)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", cfg.Name, err)
+		}
+		route.HeaderRules = headers
+		clientCert, err := ClientCertPolicyFromConfig(cfg.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", cfg.Name, err)
+		}
+		route.ClientCert = clientCert
+		route.Retry = cfg.Retry
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Matches reports whether every condition set on the route holds for req.
+func (r *Route) Matches(req *http.Request) bool {
+	if r.Host != "" && !matchHost(r.Host, req.Host) {
+		return false
+	}
+	if r.PathPrefix != "" && !hasPathPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	if r.PathRegex != nil && !r.PathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+		return false
+	}
+	for name, value := range r.Headers {
+		values, ok := req.Header[http.CanonicalHeaderKey(name)]
+		if !ok || (value != "" && !slices.Contains(values, value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathPrefix matches whole segments: /api matches /api and /api/users, not /apix or /api-admin.
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+type routeKey struct{}
+
+// RouteFromContext returns the route the Router matched for the request, nil when it went straight to a pool.
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
+
+/*
+Router fronts several pools: it matches each request against the routing table and hands it to the
+LoadBalancerUseCase of the chosen pool. Requests no route matches go to the default pool, if there is one.
+*/
+type Router struct {
+	routes []Route
+	pools  map[string]*LoadBalancerUseCase
+}
+
+func NewRouter(pools map[string]*LoadBalancerUseCase, routes []Route) (*Router, error) {
+	for _, route := range routes {
+		if _, ok := pools[route.Pool]; !ok {
+			return nil, fmt.Errorf("route %q: unknown pool %q", route.Name, route.Pool)
+		}
+	}
+	return &Router{
+		routes: routes,
+		pools:  pools,
+	}, nil
+}
+
+func (r *Router) Match(req *http.Request) (*Route, *LoadBalancerUseCase) {
+	for i := range r.routes {
+		if r.routes[i].Matches(req) {
+			return &r.routes[i], r.pools[r.routes[i].Pool]
+		}
+	}
+	return nil, r.pools[utils.DefaultPool]
+}
+
+func (r *Router) HandleRequest(req *http.Request, w http.ResponseWriter) error {
+	route, pool := r.Match(req)
+	if pool == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return http_errors.ErrNoRoute
+	}
+	if route != nil {
+		if err := route.authorize(req, w); err != nil {
+			return err
+		}
+		req = req.WithContext(context.WithValue(req.Context(), routeKey{}, route))
+	}
+	return pool.HandleRequest(req, w)
+}
+
+// GetHealthyBackends counts the healthy backends across every pool.
+func (r *Router) GetHealthyBackends() int {
+	count := 0
+	for _, pool := range r.pools {
+		count += pool.GetHealthyBackends()
+	}
+	return count
+}
@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	newPool := func(name string) (*LoadBalancerUseCase, *httptest.Server) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		b, _ := models.NewBackend(upstream.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(b)
+		return NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}), upstream
+	}
+	api, apiServer := newPool("api")
+	defer apiServer.Close()
+	static, staticServer := newPool("static")
+	defer staticServer.Close()
+	admin, adminServer := newPool("admin")
+	defer adminServer.Close()
+	web, webServer := newPool("default")
+	defer webServer.Close()
+
+	routes, err := RoutesFromConfig([]utils.RouteConfig{
+		{Name: "admin", Host: "admin.example.com", Headers: map[string]string{"X-Admin-Token": ""}, Pool: "admin"},
+		{Name: "api-write", Host: "*.example.com", PathPrefix: "/api/", Methods: []string{"post", "put"}, Headers: map[string]string{"X-Tenant": "acme"}, Pool: "api"},
+		{Name: "api-read", PathPrefix: "/api/", Methods: []string{"GET"}, Pool: "api"},
+		{Name: "assets", PathRegex: `\.(css|js|png)$`, Pool: "static"},
+		{Name: "billing", PathPrefix: "/billing", Pool: "admin"},
+	})
+	assert.NoError(t, err, "Expected valid routes")
+	router, err := NewRouter(map[string]*LoadBalancerUseCase{
+		"api":             api,
+		"static":          static,
+		"admin":           admin,
+		utils.DefaultPool: web,
+	}, routes)
+	assert.NoError(t, err, "Expected valid router")
+
+	tests := []struct {
+		name     string
+		method   string
+		host     string
+		path     string
+		headers  map[string]string
+		expected string
+	}{
+		{name: "HostAndHeaderPresence", method: "GET", host: "admin.example.com", path: "/", headers: map[string]string{"X-Admin-Token": "t"}, expected: "admin"},
+		{name: "HostWithoutHeader", method: "GET", host: "admin.example.com", path: "/", expected: "default"},
+		{name: "WildcardHostMethodAndHeader", method: "POST", host: "eu.example.com:8080", path: "/api/orders", headers: map[string]string{"X-Tenant": "acme"}, expected: "api"},
+		{name: "HeaderValueMismatch", method: "POST", host: "eu.example.com", path: "/api/orders", headers: map[string]string{"X-Tenant": "other"}, expected: "default"},
+		{name: "MethodOnly", method: "GET", host: "anything.test", path: "/api/orders", expected: "api"},
+		{name: "PathRegex", method: "GET", host: "anything.test", path: "/assets/site.css", expected: "static"},
+		{name: "PathPrefixItself", method: "GET", host: "anything.test", path: "/billing", expected: "admin"},
+		{name: "PathPrefixSegment", method: "GET", host: "anything.test", path: "/billing/invoices", expected: "admin"},
+		{name: "PathPrefixIsNotAStringPrefix", method: "GET", host: "anything.test", path: "/billing-admin", expected: "default"},
+		{name: "NoRouteUsesDefaultPool", method: "GET", host: "anything.test", path: "/home", expected: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Host = tt.host
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			err := router.HandleRequest(req, w)
+
+			assert.NoError(t, err, "Expected no error")
+			assert.Equal(t, tt.expected, w.Body.String(), "Request reached the wrong pool")
+		})
+	}
+
+	t.Run("HealthyBackendsAcrossPools", func(t *testing.T) {
+		assert.Equal(t, 4, router.GetHealthyBackends(), "Expected the healthy backends of every pool")
+	})
+
+	t.Run("NoDefaultPool", func(t *testing.T) {
+		r, err := NewRouter(map[string]*LoadBalancerUseCase{"api": api}, nil)
+		assert.NoError(t, err, "Expected valid router")
+		w := httptest.NewRecorder()
+		err = r.HandleRequest(httptest.NewRequest("GET", "/", nil), w)
+		assert.Equal(t, http_errors.ErrNoRoute, err, "Expected ErrNoRoute")
+		assert.Equal(t, http.StatusNotFound, w.Code, "Expected 404 without a matching route")
+	})
+
+	t.Run("UnknownPool", func(t *testing.T) {
+		routes, _ := RoutesFromConfig([]utils.RouteConfig{{Name: "broken", Pool: "missing"}})
+		_, err := NewRouter(map[string]*LoadBalancerUseCase{"api": api}, routes)
+		assert.Error(t, err, "Expected a route to an unknown pool to be rejected")
+	})
+}
+
+func TestRouteConfigValidation(t *testing.T) {
+	base := func() *utils.Config {
+		return &utils.Config{
+			Port:           "8080",
+			HealthInterval: 1,
+			Algorithm:      "round_robin",
+			Pools: map[string]utils.PoolConfig{
+				"api": {Backends: []utils.BackendConfig{{URL: "http://localhost:9001"}}},
+			},
+		}
+	}
+
+	cfg := base()
+	cfg.Routes = []utils.RouteConfig{{Name: "api", PathPrefix: "/api", Pool: "api"}}
+	assert.NoError(t, utils.ValidateConfig(cfg, BalancerNames()), "Pools without top-level backends should be valid")
+
+	cfg = base()
+	cfg.Routes = []utils.RouteConfig{{Name: "api", Pool: "missing"}}
+	assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Route to an unknown pool should be rejected")
+
+	cfg = base()
+	cfg.Routes = []utils.RouteConfig{{Name: "api", PathPrefix: "/api"}}
+	assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Route to the default pool needs top-level backends")
+
+	cfg = base()
+	cfg.Routes = []utils.RouteConfig{{Name: "api", PathRegex: "([", Pool: "api"}}
+	assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Invalid path regex should be rejected")
+
+	cfg = base()
+	cfg.Pools["postgres"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "tcp://10.0.0.1:5432"}}}
+	cfg.Routes = []utils.RouteConfig{{Name: "db", PathPrefix: "/db", Pool: "postgres"}}
+	assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Route to a pool of tcp:// backends should be rejected")
+
+	cfg = base()
+	cfg.Pools = nil
+	assert.Error(t, utils.ValidateConfig(cfg, BalancerNames()), "Either backends or pools is required")
+}
@@ -0,0 +1,177 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/metrics"
+	"GoRelay/pkg/proxyproto"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	DefaultConnectTimeout  time.Duration = 5 * time.Second
+	DefaultConnectAttempts int           = 3
+)
+
+var (
+	tcpConns = metrics.Default.NewGaugeVec("gorelay_tcp_connections",
+		"Client connections currently proxied at layer 4.", "backend")
+	tcpConnectFailures = metrics.Default.NewCounterVec("gorelay_tcp_connect_failures_total",
+		"Failed connection attempts to TCP backends, each one is failed over to another backend.", "backend")
+)
+
+/*
+TCPProxy balances raw TCP connections (databases, caches, anything not HTTP) over the pool of a
+LoadBalancerUseCase, with the same balancer, health state and outlier detection as HTTP traffic.
+A connection that can't be established is retried on another backend, up to the connect attempts.
+*/
+type TCPProxy struct {
+	uc              *LoadBalancerUseCase
+	connectTimeout  time.Duration
+	connectAttempts int
+	idleTimeout     time.Duration
+	proxyProtocol   int
+}
+
+type TCPOption func(*TCPProxy)
+
+// WithConnectTimeout bounds each attempt to connect to a backend, zero keeps DefaultConnectTimeout.
+func WithConnectTimeout(timeout time.Duration) TCPOption {
+	return func(p *TCPProxy) {
+		if timeout > 0 {
+			p.connectTimeout = timeout
+		}
+	}
+}
+
+// WithConnectAttempts sets how many backends are tried per client connection, zero keeps DefaultConnectAttempts.
+func WithConnectAttempts(attempts int) TCPOption {
+	return func(p *TCPProxy) {
+		if attempts > 0 {
+			p.connectAttempts = attempts
+		}
+	}
+}
+
+// WithTCPIdleTimeout closes connections without traffic in either direction for that long, zero keeps them open.
+func WithTCPIdleTimeout(timeout time.Duration) TCPOption {
+	return func(p *TCPProxy) {
+		p.idleTimeout = timeout
+	}
+}
+
+/*
+WithSendProxyProtocol starts every backend connection with a PROXY protocol header of that version
+(1 or 2) carrying the client's address, zero sends none.
+*/
+func WithSendProxyProtocol(version int) TCPOption {
+	return func(p *TCPProxy) {
+		p.proxyProtocol = version
+	}
+}
+
+func NewTCPProxy(uc *LoadBalancerUseCase, opts ...TCPOption) *TCPProxy {
+	p := &TCPProxy{
+		uc:              uc,
+		connectTimeout:  DefaultConnectTimeout,
+		connectAttempts: DefaultConnectAttempts,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+/*
+HandleConn proxies conn to a backend until both sides are done or ctx ends, which is how the listener
+cuts off connections still open when its shutdown deadline passes. conn is always closed.
+*/
+func (p *TCPProxy) HandleConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	backend, upstream, err := p.connect(ctx, conn, &RequestContext{ClientAddr: conn.RemoteAddr().String()})
+	if err != nil {
+		return err
+	}
+	defer upstream.Close()
+
+	backend.IncrementConnections()
+	defer backend.DecrementConnections()
+	gauge := tcpConns.With(backend.URL.String())
+	gauge.Inc()
+	defer gauge.Dec()
+
+	closeBoth := func() {
+		conn.Close()
+		upstream.Close()
+	}
+	stop := context.AfterFunc(ctx, closeBoth)
+	defer stop()
+
+	var toBackend, toClient io.Reader = conn, upstream
+	if p.idleTimeout > 0 {
+		watch := newIdleWatch(p.idleTimeout, closeBoth)
+		defer watch.stop()
+		toBackend, toClient = activityReader{conn, watch}, activityReader{upstream, watch}
+	}
+	done := make(chan struct{}, 2)
+	go copyHalf(upstream, toBackend, closeBoth, done)
+	go copyHalf(conn, toClient, closeBoth, done)
+	<-done
+	<-done
+	return nil
+}
+
+// copyHalf copies one direction and half-closes it at EOF, so the other direction can still finish.
+func copyHalf(dst net.Conn, src io.Reader, closeBoth func(), done chan<- struct{}) {
+	defer func() { done <- struct{}{} }()
+	if _, err := io.Copy(dst, src); err != nil {
+		closeBoth()
+		return
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	closeBoth()
+}
+
+// connect dials the balancer's pick for the client conn, failing over to backends not tried yet.
+func (p *TCPProxy) connect(ctx context.Context, conn net.Conn, rc *RequestContext) (*models.Backend, net.Conn, error) {
+	rc.Exclude = make(map[*models.Backend]bool, p.connectAttempts)
+	dialer := net.Dialer{Timeout: p.connectTimeout}
+	var lastErr error
+	for range p.connectAttempts {
+		backend := p.uc.selectExcluding(rc)
+		if backend == nil {
+			break
+		}
+		rc.Exclude[backend] = true
+		upstream, err := dialer.DialContext(ctx, "tcp", backend.URL.Host)
+		if ctx.Err() != nil {
+			if err == nil {
+				upstream.Close()
+			}
+			return nil, nil, ctx.Err()
+		}
+		if err == nil && p.proxyProtocol > 0 {
+			if _, err = proxyproto.NewHeader(p.proxyProtocol, conn.RemoteAddr(), conn.LocalAddr()).WriteTo(upstream); err != nil {
+				upstream.Close()
+			}
+		}
+		// The outcome feeds outlier detection like an HTTP attempt: a failed connect counts as a gateway error
+		p.uc.recordOutcome(backend, 0, err)
+		if err == nil {
+			return backend, upstream, nil
+		}
+		tcpConnectFailures.With(backend.URL.String()).Inc()
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, nil, http_errors.ErrNoHealthyBackend
+	}
+	return nil, nil, fmt.Errorf("%w: %w", http_errors.ErrUpstreamFailed, lastErr)
+}
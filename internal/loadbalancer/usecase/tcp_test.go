@@ -0,0 +1,209 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/proxyproto"
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTCPEcho serves a line echo protocol; on EOF from the client it answers "bye" before closing.
+func newTCPEcho(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err == io.EOF {
+						conn.Write([]byte("bye\n"))
+						return
+					}
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+// deadAddr is an address nothing listens on any more.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestTCPProxy(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	echo := newTCPEcho(t)
+	defer echo.Close()
+
+	newProxy := func(addrs []string, opts ...TCPOption) (*models.ServerPool, *TCPProxy) {
+		pool := models.NewServerPool()
+		for _, addr := range addrs {
+			b, _ := models.NewBackend("tcp://" + addr)
+			pool.AddBackend(b)
+		}
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+		return pool, NewTCPProxy(uc, opts...)
+	}
+	// serve runs the proxy for one client connection and returns the client side and HandleConn's result.
+	serve := func(t *testing.T, p *TCPProxy) (*net.TCPConn, <-chan error) {
+		t.Helper()
+		front, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		t.Cleanup(func() { front.Close() })
+		result := make(chan error, 1)
+		go func() {
+			conn, err := front.Accept()
+			if err != nil {
+				result <- err
+				return
+			}
+			result <- p.HandleConn(context.Background(), conn)
+		}()
+		client, err := net.Dial("tcp", front.Addr().String())
+		assert.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client.(*net.TCPConn), result
+	}
+
+	t.Run("ProxiesAndCountsConnections", func(t *testing.T) {
+		pool, p := newProxy([]string{echo.Addr().String()})
+		client, result := serve(t, p)
+		r := bufio.NewReader(client)
+
+		client.Write([]byte("PING\n"))
+		line, _ := r.ReadString('\n')
+		assert.Equal(t, "PING\n", line, "Expected the bytes to be echoed through the proxy")
+		assert.Equal(t, 1, pool.Backends[0].GetActiveConnections(), "Expected the connection to be counted")
+
+		// A half-close reaches the backend and its last answer still comes back
+		client.CloseWrite()
+		line, _ = r.ReadString('\n')
+		assert.Equal(t, "bye\n", line, "Expected the backend's reply after the client's EOF")
+		assert.NoError(t, <-result)
+		assert.Equal(t, 0, pool.Backends[0].GetActiveConnections(), "Expected the count to drop when the connection ends")
+	})
+
+	t.Run("FailsOverOnConnectError", func(t *testing.T) {
+		pool, p := newProxy([]string{deadAddr(t), echo.Addr().String()})
+		client, _ := serve(t, p)
+		client.Write([]byte("hello\n"))
+		line, err := bufio.NewReader(client).ReadString('\n')
+		assert.NoError(t, err, "Expected the live backend to take the connection")
+		assert.Equal(t, "hello\n", line)
+
+		requests, successes := pool.Backends[0].TakeOutlierWindow()
+		assert.Equal(t, uint64(1), requests, "Expected the failed connect to be recorded")
+		assert.Equal(t, uint64(0), successes, "A failed connect counts against the backend")
+	})
+
+	t.Run("AllBackendsDown", func(t *testing.T) {
+		_, p := newProxy([]string{deadAddr(t), deadAddr(t)}, WithConnectTimeout(time.Second))
+		client, result := serve(t, p)
+
+		assert.ErrorIs(t, <-result, http_errors.ErrUpstreamFailed, "Expected the failure to be reported")
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := client.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "Expected the client to be disconnected")
+	})
+
+	t.Run("NoHealthyBackends", func(t *testing.T) {
+		pool, p := newProxy([]string{echo.Addr().String()})
+		pool.Backends[0].SetAlive(false)
+		_, result := serve(t, p)
+
+		assert.ErrorIs(t, <-result, http_errors.ErrNoHealthyBackend)
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		_, p := newProxy([]string{echo.Addr().String()}, WithTCPIdleTimeout(100*time.Millisecond))
+		client, result := serve(t, p)
+		r := bufio.NewReader(client)
+		for range 3 {
+			time.Sleep(50 * time.Millisecond)
+			client.Write([]byte("still here\n"))
+			_, err := r.ReadString('\n')
+			assert.NoError(t, err, "Traffic should keep the connection open")
+		}
+		select {
+		case <-result:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the idle connection to be closed")
+		}
+	})
+
+	t.Run("SendsProxyProtocol", func(t *testing.T) {
+		backend, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer backend.Close()
+		headers := make(chan *proxyproto.Header, 1)
+		go func() {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			h, _ := proxyproto.Read(bufio.NewReader(conn))
+			headers <- h
+		}()
+		_, p := newProxy([]string{backend.Addr().String()}, WithSendProxyProtocol(2))
+		client, _ := serve(t, p)
+
+		h := <-headers
+		assert.NotNil(t, h, "Expected the backend to receive a header")
+		assert.Equal(t, 2, h.Version)
+		assert.Equal(t, client.LocalAddr().String(), h.Source.String(), "Expected the client as the source")
+		assert.Equal(t, client.RemoteAddr().String(), h.Destination.String(), "Expected the listener as the destination")
+	})
+
+	t.Run("ContextEndsConnection", func(t *testing.T) {
+		_, p := newProxy([]string{echo.Addr().String()})
+		front, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer front.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			conn, _ := front.Accept()
+			result <- p.HandleConn(ctx, conn)
+		}()
+		client, _ := net.Dial("tcp", front.Addr().String())
+		defer client.Close()
+		client.Write([]byte("x\n"))
+		bufio.NewReader(client).ReadString('\n')
+
+		cancel()
+		select {
+		case <-result:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the connection to be cut off with its context")
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/http_errors"
+	"GoRelay/pkg/metrics"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUDPSessionTimeout time.Duration = 30 * time.Second
+	DefaultUDPMaxSessions    int           = 10000
+	maxDatagramSize          int           = 64 * 1024
+)
+
+var errUDPSessionLimit = errors.New("udp session limit reached")
+
+var (
+	udpSessions = metrics.Default.NewGaugeVec("gorelay_udp_sessions",
+		"Client flows currently mapped to a UDP backend.", "backend")
+	udpPackets = metrics.Default.NewCounterVec("gorelay_udp_packets_total",
+		"Datagrams relayed, to the backend or back to the client.", "backend", "direction")
+	udpBytes = metrics.Default.NewCounterVec("gorelay_udp_bytes_total",
+		"Payload bytes relayed, to the backend or back to the client.", "backend", "direction")
+	udpRejectedSessions = metrics.Default.NewCounterVec("gorelay_udp_sessions_rejected_total",
+		"Datagrams from new client addresses dropped because the session limit was reached.")
+)
+
+/*
+UDPProxy balances datagrams over the pool of a LoadBalancerUseCase. Each client address is a session,
+pinned to the backend the balancer picked for its first datagram, and gets its own socket towards that
+backend so replies can be told apart and relayed back. Sessions expire after the session timeout
+without traffic either way; a backend refusing datagrams (ICMP port unreachable) ends the session and
+counts against it in outlier detection, the client's next datagram picks another backend. Every
+session holds a socket, so their number is capped: datagrams from new addresses are dropped while the
+limit is reached, as source addresses are easily spoofed.
+*/
+type UDPProxy struct {
+	uc          *LoadBalancerUseCase
+	timeout     time.Duration
+	maxSessions int
+
+	mux      sync.Mutex
+	sessions map[string]*udpSession
+}
+
+type UDPOption func(*UDPProxy)
+
+// WithUDPSessionTimeout sets how long a session lives without traffic, zero keeps DefaultUDPSessionTimeout.
+func WithUDPSessionTimeout(timeout time.Duration) UDPOption {
+	return func(p *UDPProxy) {
+		if timeout > 0 {
+			p.timeout = timeout
+		}
+	}
+}
+
+// WithUDPMaxSessions bounds how many client flows are mapped at once, zero keeps DefaultUDPMaxSessions.
+func WithUDPMaxSessions(n int) UDPOption {
+	return func(p *UDPProxy) {
+		if n > 0 {
+			p.maxSessions = n
+		}
+	}
+}
+
+func NewUDPProxy(uc *LoadBalancerUseCase, opts ...UDPOption) *UDPProxy {
+	p := &UDPProxy{
+		uc:          uc,
+		timeout:     DefaultUDPSessionTimeout,
+		maxSessions: DefaultUDPMaxSessions,
+		sessions:    map[string]*udpSession{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+type udpSession struct {
+	key      string
+	client   net.Addr
+	backend  *models.Backend
+	upstream net.Conn
+	watch    *idleWatch
+	replied  bool // only touched by the reply loop
+	once     sync.Once
+}
+
+// Sessions returns the number of client flows currently mapped to a backend.
+func (p *UDPProxy) Sessions() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return len(p.sessions)
+}
+
+/*
+ServePackets relays datagrams received on conn until it is closed or ctx ends, then ends every session.
+Datagrams that can't be forwarded are dropped, as a network would.
+*/
+func (p *UDPProxy) ServePackets(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer p.closeAll()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s, err := p.session(conn, addr)
+		if err != nil {
+			continue
+		}
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			p.fail(s, err)
+			continue
+		}
+		s.watch.touch()
+		label := s.backend.URL.String()
+		udpPackets.With(label, "to_backend").Inc()
+		udpBytes.With(label, "to_backend").Add(float64(n))
+	}
+}
+
+// session returns the client's session, creating one on a backend chosen by the balancer.
+func (p *UDPProxy) session(conn net.PacketConn, client net.Addr) (*udpSession, error) {
+	key := client.String()
+	p.mux.Lock()
+	s, ok := p.sessions[key]
+	full := len(p.sessions) >= p.maxSessions
+	p.mux.Unlock()
+	if ok {
+		return s, nil
+	}
+	if full {
+		udpRejectedSessions.With().Inc()
+		return nil, errUDPSessionLimit
+	}
+	backend := p.uc.balancer.Select(p.uc.Pool, &RequestContext{ClientAddr: key})
+	if backend == nil {
+		return nil, http_errors.ErrNoHealthyBackend
+	}
+	// Dialed without the lock, resolving the backend's name must not hold up the other flows
+	upstream, err := net.Dial("udp", backend.URL.Host)
+	if err != nil {
+		p.uc.recordOutcome(backend, 0, err)
+		return nil, err
+	}
+
+	p.mux.Lock()
+	if existing, ok := p.sessions[key]; ok {
+		p.mux.Unlock()
+		upstream.Close()
+		return existing, nil
+	}
+	if len(p.sessions) >= p.maxSessions {
+		p.mux.Unlock()
+		upstream.Close()
+		udpRejectedSessions.With().Inc()
+		return nil, errUDPSessionLimit
+	}
+	s = &udpSession{key: key, client: client, backend: backend, upstream: upstream}
+	s.watch = newIdleWatch(p.timeout, func() { p.end(s) })
+	p.sessions[key] = s
+	backend.IncrementConnections()
+	udpSessions.With(backend.URL.String()).Inc()
+	p.mux.Unlock()
+	go p.relayReplies(conn, s)
+	return s, nil
+}
+
+// relayReplies sends the backend's datagrams back to the client until the session ends.
+func (p *UDPProxy) relayReplies(conn net.PacketConn, s *udpSession) {
+	buf := make([]byte, maxDatagramSize)
+	label := s.backend.URL.String()
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.fail(s, err)
+			}
+			return
+		}
+		if !s.replied {
+			s.replied = true
+			p.uc.recordOutcome(s.backend, 0, nil)
+		}
+		s.watch.touch()
+		if _, err := conn.WriteTo(buf[:n], s.client); err != nil {
+			continue
+		}
+		udpPackets.With(label, "to_client").Inc()
+		udpBytes.With(label, "to_client").Add(float64(n))
+	}
+}
+
+// fail ends a session whose backend refused it, so outlier detection hears about the backend.
+func (p *UDPProxy) fail(s *udpSession, err error) {
+	p.uc.recordOutcome(s.backend, 0, err)
+	p.end(s)
+}
+
+func (p *UDPProxy) end(s *udpSession) {
+	s.once.Do(func() {
+		p.mux.Lock()
+		if p.sessions[s.key] == s {
+			delete(p.sessions, s.key)
+		}
+		p.mux.Unlock()
+		s.watch.stop()
+		s.upstream.Close()
+		s.backend.DecrementConnections()
+		udpSessions.With(s.backend.URL.String()).Dec()
+	})
+}
+
+func (p *UDPProxy) closeAll() {
+	p.mux.Lock()
+	sessions := make([]*udpSession, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mux.Unlock()
+	for _, s := range sessions {
+		p.end(s)
+	}
+}
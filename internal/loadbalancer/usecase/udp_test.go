@@ -0,0 +1,165 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newUDPEcho answers every datagram with name:payload.
+func newUDPEcho(t *testing.T, name string) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(name+":"+string(buf[:n])), addr)
+		}
+	}()
+	return conn
+}
+
+func TestUDPProxy(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	// start serves the proxy on a local socket and stops it when the test ends.
+	start := func(t *testing.T, addrs []string, opts ...UDPOption) (*models.ServerPool, *UDPProxy, string) {
+		t.Helper()
+		pool := models.NewServerPool()
+		for _, addr := range addrs {
+			b, _ := models.NewBackend("udp://" + addr)
+			pool.AddBackend(b)
+		}
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{})
+		p := NewUDPProxy(uc, opts...)
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- p.ServePackets(ctx, conn) }()
+		t.Cleanup(func() {
+			cancel()
+			assert.NoError(t, <-done, "Expected a clean stop")
+		})
+		return pool, p, conn.LocalAddr().String()
+	}
+	exchange := func(t *testing.T, client net.Conn, msg string) string {
+		t.Helper()
+		client.Write([]byte(msg))
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		assert.NoError(t, err, "Expected a reply")
+		return string(buf[:n])
+	}
+
+	t.Run("SessionsStickToABackend", func(t *testing.T) {
+		a, b := newUDPEcho(t, "a"), newUDPEcho(t, "b")
+		pool, p, addr := start(t, []string{a.LocalAddr().String(), b.LocalAddr().String()})
+		first, _ := net.Dial("udp", addr)
+		defer first.Close()
+		second, _ := net.Dial("udp", addr)
+		defer second.Close()
+
+		label := pool.Backends[0].URL.String()
+		sentBefore := udpPackets.With(label, "to_backend").Value()
+		bytesBefore := udpBytes.With(label, "to_client").Value()
+
+		assert.Equal(t, "a:one", exchange(t, first, "one"), "Expected the first client on the first backend")
+		assert.Equal(t, "b:one", exchange(t, second, "one"), "Expected the next client on the next backend")
+		assert.Equal(t, "a:two", exchange(t, first, "two"), "Expected a client to keep its backend")
+
+		assert.Equal(t, 2, p.Sessions(), "Expected one session per client address")
+		assert.Equal(t, 1, pool.Backends[0].GetActiveConnections(), "Expected sessions to count as connections")
+		assert.Equal(t, float64(2), udpPackets.With(label, "to_backend").Value()-sentBefore, "Expected the packets to be counted per backend")
+		assert.Equal(t, float64(len("a:one")+len("a:two")), udpBytes.With(label, "to_client").Value()-bytesBefore, "Expected the reply bytes to be counted")
+	})
+
+	t.Run("IdleSessionsExpire", func(t *testing.T) {
+		a := newUDPEcho(t, "a")
+		pool, p, addr := start(t, []string{a.LocalAddr().String()}, WithUDPSessionTimeout(100*time.Millisecond))
+		client, _ := net.Dial("udp", addr)
+		defer client.Close()
+
+		exchange(t, client, "ping")
+		assert.Equal(t, 1, p.Sessions())
+		assert.Eventually(t, func() bool {
+			return p.Sessions() == 0 && pool.Backends[0].GetActiveConnections() == 0
+		}, time.Second, 10*time.Millisecond, "Expected the session to expire")
+
+		assert.Equal(t, "a:again", exchange(t, client, "again"), "Expected a new session after expiry")
+	})
+
+	t.Run("RefusedBackendIsLeft", func(t *testing.T) {
+		dead, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		deadAddr := dead.LocalAddr().String()
+		dead.Close()
+		live := newUDPEcho(t, "live")
+		pool, _, addr := start(t, []string{deadAddr, live.LocalAddr().String()})
+		client, _ := net.Dial("udp", addr)
+		defer client.Close()
+
+		// The first datagram goes to the dead backend; its ICMP error ends the session
+		var reply string
+		assert.Eventually(t, func() bool {
+			client.Write([]byte("query"))
+			client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			buf := make([]byte, 1500)
+			n, err := client.Read(buf)
+			reply = string(buf[:max(n, 0)])
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond, "Expected the client to be moved to the live backend")
+		assert.True(t, strings.HasPrefix(reply, "live:"), "Expected the reply from the live backend")
+
+		requests, successes := pool.Backends[0].TakeOutlierWindow()
+		assert.GreaterOrEqual(t, requests, uint64(1), "Expected the refusal to be recorded")
+		assert.Equal(t, uint64(0), successes, "A refusal counts against the backend")
+	})
+
+	t.Run("NoHealthyBackendsDrops", func(t *testing.T) {
+		a := newUDPEcho(t, "a")
+		pool, p, addr := start(t, []string{a.LocalAddr().String()})
+		pool.Backends[0].SetAlive(false)
+		client, _ := net.Dial("udp", addr)
+		defer client.Close()
+
+		client.Write([]byte("lost"))
+		client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := client.Read(make([]byte, 16))
+		assert.Error(t, err, "Expected the datagram to be dropped")
+		assert.Equal(t, 0, p.Sessions())
+	})
+
+	t.Run("SessionLimitDropsNewFlows", func(t *testing.T) {
+		a := newUDPEcho(t, "a")
+		_, p, addr := start(t, []string{a.LocalAddr().String()}, WithUDPMaxSessions(1))
+		first, _ := net.Dial("udp", addr)
+		defer first.Close()
+		second, _ := net.Dial("udp", addr)
+		defer second.Close()
+
+		rejectedBefore := udpRejectedSessions.With().Value()
+		assert.Equal(t, "a:one", exchange(t, first, "one"))
+		second.Write([]byte("lost"))
+		second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := second.Read(make([]byte, 16))
+		assert.Error(t, err, "Expected a new flow beyond the limit to be dropped")
+		assert.Equal(t, 1, p.Sessions())
+		assert.Equal(t, float64(1), udpRejectedSessions.With().Value()-rejectedBefore, "Expected the drop to be counted")
+		assert.Equal(t, "a:two", exchange(t, first, "two"), "Expected the existing flow to carry on")
+	})
+}
@@ -0,0 +1,213 @@
+package usecase
+
+import (
+	"GoRelay/internal/models"
+	"GoRelay/pkg/metrics"
+	"GoRelay/pkg/utils"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const DefaultDrainTimeout time.Duration = 10 * time.Second
+
+var (
+	upgradedConns = metrics.Default.NewGaugeVec("gorelay_upgraded_connections",
+		"Client connections currently switched to another protocol (WebSocket and other Upgrade requests).", "backend")
+	terminatedUpgrades = metrics.Default.NewCounterVec("gorelay_upgraded_connections_terminated_total",
+		"Upgraded connections closed by GoRelay rather than by either peer.", "reason")
+
+	errDraining = errors.New("shutting down, upgrade refused")
+)
+
+/*
+UpgradePolicy limits connections taken over by another protocol after a 101 Switching Protocols.
+IdleTimeout closes a connection with no bytes in either direction for that long, MaxLifetime
+closes it that long after the upgrade; zero leaves the connection open. DrainTimeout is how long
+shutdown waits for the remaining ones to finish before closing them.
+*/
+type UpgradePolicy struct {
+	IdleTimeout  time.Duration
+	MaxLifetime  time.Duration
+	DrainTimeout time.Duration
+}
+
+func DefaultUpgradePolicy() UpgradePolicy {
+	return UpgradePolicy{DrainTimeout: DefaultDrainTimeout}
+}
+
+func UpgradePolicyFromConfig(cfg utils.UpgradeConfig) UpgradePolicy {
+	p := DefaultUpgradePolicy()
+	p.IdleTimeout, p.MaxLifetime = cfg.IdleTimeout, cfg.MaxLifetime
+	if cfg.DrainTimeout > 0 {
+		p.DrainTimeout = cfg.DrainTimeout
+	}
+	return p
+}
+
+// WithUpgrades sets the limits of upgraded connections and the tracker shutdown drains, shared by all pools.
+func WithUpgrades(policy UpgradePolicy, tracker *UpgradeTracker) Option {
+	return func(uc *LoadBalancerUseCase) {
+		uc.upgrade = policy
+		if tracker != nil {
+			uc.upgrades = tracker
+		}
+	}
+}
+
+/*
+UpgradeTracker keeps the upgraded connections open across the proxy. http.Server.Shutdown forgets
+hijacked connections, so they are drained here instead.
+*/
+type UpgradeTracker struct {
+	mux      sync.Mutex
+	conns    map[*upgradedConn]struct{}
+	draining bool
+}
+
+func NewUpgradeTracker() *UpgradeTracker {
+	return &UpgradeTracker{conns: map[*upgradedConn]struct{}{}}
+}
+
+// Active returns the number of upgraded connections currently open.
+func (t *UpgradeTracker) Active() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return len(t.conns)
+}
+
+/*
+Drain refuses further upgrades and waits for the open connections to be closed by their peers.
+Whatever is still open when ctx ends is closed, and ctx's error returned.
+*/
+func (t *UpgradeTracker) Drain(ctx context.Context) error {
+	t.mux.Lock()
+	t.draining = true
+	t.mux.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for t.Active() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			t.mux.Lock()
+			remaining := make([]*upgradedConn, 0, len(t.conns))
+			for c := range t.conns {
+				remaining = append(remaining, c)
+			}
+			t.mux.Unlock()
+			for _, c := range remaining {
+				c.terminate("drain")
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+/*
+track wraps a hijacked client connection, enforcing the policy's limits. Bytes the server had
+already read past the upgrade request are in buffered, the ReverseProxy would lose them otherwise.
+*/
+func (t *UpgradeTracker) track(conn net.Conn, buffered *bufio.Reader, backend *models.Backend, policy UpgradePolicy) (net.Conn, error) {
+	c := &upgradedConn{Conn: conn, r: conn, tracker: t, gauge: upgradedConns.With(backend.URL.String())}
+	if buffered != nil && buffered.Buffered() > 0 {
+		c.r = io.MultiReader(io.LimitReader(buffered, int64(buffered.Buffered())), conn)
+	}
+	t.mux.Lock()
+	if t.draining {
+		t.mux.Unlock()
+		conn.Close()
+		return nil, errDraining
+	}
+	t.conns[c] = struct{}{}
+	t.mux.Unlock()
+	c.gauge.Inc()
+
+	c.timerMux.Lock()
+	defer c.timerMux.Unlock()
+	if policy.IdleTimeout > 0 {
+		c.idle = newIdleWatch(policy.IdleTimeout, func() { c.terminate("idle") })
+	}
+	if policy.MaxLifetime > 0 {
+		c.lifetimeTimer = time.AfterFunc(policy.MaxLifetime, func() { c.terminate("lifetime") })
+	}
+	return c, nil
+}
+
+// upgradedConn is the client side of an upgraded connection, recording activity for the idle timeout.
+type upgradedConn struct {
+	net.Conn
+	r       io.Reader
+	tracker *UpgradeTracker
+	gauge   *metrics.Gauge
+
+	idle          *idleWatch // nil without an idle timeout
+	timerMux      sync.Mutex
+	lifetimeTimer *time.Timer
+	closeOnce     sync.Once
+	closeErr      error
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && c.idle != nil {
+		c.idle.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 && c.idle != nil {
+		c.idle.touch()
+	}
+	return n, err
+}
+
+// CloseWrite passes the half-close of one direction on, so the other one can finish.
+func (c *upgradedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *upgradedConn) terminate(reason string) {
+	closed := false
+	c.closeOnce.Do(func() {
+		closed = true
+		c.closeErr = c.close()
+	})
+	if closed {
+		terminatedUpgrades.With(reason).Inc()
+	}
+}
+
+func (c *upgradedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close()
+	})
+	return c.closeErr
+}
+
+func (c *upgradedConn) close() error {
+	c.timerMux.Lock()
+	if c.idle != nil {
+		c.idle.stop()
+	}
+	if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+	}
+	c.timerMux.Unlock()
+	c.tracker.mux.Lock()
+	delete(c.tracker.conns, c)
+	c.tracker.mux.Unlock()
+	c.gauge.Dec()
+	return c.Conn.Close()
+}
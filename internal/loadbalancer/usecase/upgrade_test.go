@@ -0,0 +1,186 @@
+package usecase
+
+import (
+	"GoRelay/internal/loadbalancer/mock"
+	"GoRelay/internal/models"
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEchoUpstream switches to a line echo protocol on "Upgrade: echo", like a WebSocket server would.
+func newEchoUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString(line)
+			brw.Flush()
+		}
+	}))
+}
+
+// dialUpgrade sends an upgrade request and returns the switched connection, first bytes written along with the request.
+func dialUpgrade(t *testing.T, addr string, early string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	conn.Write([]byte("GET /socket HTTP/1.1\r\nHost: gorelay\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n" + early))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NoError(t, err, "Expected a response to the upgrade")
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, "Expected the protocol switch")
+	return conn, r
+}
+
+func TestUpgradeProxying(t *testing.T) {
+	healthChecker := &mock.HealthRepositoryMock{
+		CheckHealthFunc: func(b *models.Backend) bool { return b.IsAlive() },
+	}
+	upstream := newEchoUpstream()
+	defer upstream.Close()
+	newFrontend := func(policy UpgradePolicy, tracker *UpgradeTracker) (*models.Backend, *httptest.Server) {
+		b, _ := models.NewBackend(upstream.URL)
+		pool := models.NewServerPool()
+		pool.AddBackend(b)
+		uc := NewLoadBalancerUseCase(pool, RoundRobin, healthChecker, &http.Transport{}, WithUpgrades(policy, tracker))
+		frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uc.HandleRequest(r, w)
+		}))
+		return b, frontend
+	}
+	waitClosed := func(t *testing.T, r *bufio.Reader, within time.Duration) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.ReadString('\n')
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, io.EOF, "Expected the connection to be closed")
+		case <-time.After(within):
+			t.Fatal("Expected the connection to be closed")
+		}
+	}
+
+	t.Run("BidirectionalAndCounted", func(t *testing.T) {
+		tracker := NewUpgradeTracker()
+		backend, frontend := newFrontend(DefaultUpgradePolicy(), tracker)
+		defer frontend.Close()
+
+		conn, r := dialUpgrade(t, frontend.Listener.Addr().String(), "early\n")
+		line, _ := r.ReadString('\n')
+		assert.Equal(t, "early\n", line, "Expected bytes sent with the request to reach the backend")
+		conn.Write([]byte("ping\n"))
+		line, _ = r.ReadString('\n')
+		assert.Equal(t, "ping\n", line, "Expected the message to be echoed")
+
+		assert.Equal(t, 1, backend.GetActiveConnections(), "Expected the socket to count as an active connection")
+		assert.Equal(t, 1, tracker.Active(), "Expected the socket to be tracked")
+
+		conn.Close()
+		assert.Eventually(t, func() bool {
+			return backend.GetActiveConnections() == 0 && tracker.Active() == 0
+		}, time.Second, 10*time.Millisecond, "Expected the counts to drop when the client leaves")
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		_, frontend := newFrontend(UpgradePolicy{IdleTimeout: 150 * time.Millisecond}, nil)
+		defer frontend.Close()
+
+		conn, r := dialUpgrade(t, frontend.Listener.Addr().String(), "")
+		defer conn.Close()
+		for range 4 {
+			time.Sleep(75 * time.Millisecond)
+			conn.Write([]byte("keepalive\n"))
+			line, err := r.ReadString('\n')
+			assert.NoError(t, err, "Traffic should keep the connection open")
+			assert.Equal(t, "keepalive\n", line)
+		}
+		waitClosed(t, r, time.Second)
+	})
+
+	t.Run("MaxLifetime", func(t *testing.T) {
+		_, frontend := newFrontend(UpgradePolicy{MaxLifetime: 200 * time.Millisecond}, nil)
+		defer frontend.Close()
+
+		conn, r := dialUpgrade(t, frontend.Listener.Addr().String(), "")
+		defer conn.Close()
+		start := time.Now()
+		go func() {
+			for time.Since(start) < time.Second {
+				if _, err := conn.Write([]byte("busy\n")); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				break
+			}
+		}
+		assert.Less(t, time.Since(start), time.Second, "Expected a busy connection to be closed at its max lifetime")
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		tracker := NewUpgradeTracker()
+		backend, frontend := newFrontend(DefaultUpgradePolicy(), tracker)
+		defer frontend.Close()
+
+		conn, r := dialUpgrade(t, frontend.Listener.Addr().String(), "")
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := tracker.Drain(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "Expected a socket outliving the grace period")
+		waitClosed(t, r, time.Second)
+		assert.Eventually(t, func() bool { return backend.GetActiveConnections() == 0 }, time.Second, 10*time.Millisecond)
+
+		// Nothing new is upgraded once draining started
+		late, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer late.Close()
+		late.Write([]byte("GET /socket HTTP/1.1\r\nHost: gorelay\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+		late.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = http.ReadResponse(bufio.NewReader(late), nil)
+		assert.Error(t, err, "Expected the late upgrade to be refused")
+	})
+
+	t.Run("DrainFinishesEarly", func(t *testing.T) {
+		tracker := NewUpgradeTracker()
+		_, frontend := newFrontend(DefaultUpgradePolicy(), tracker)
+		defer frontend.Close()
+
+		conn, _ := dialUpgrade(t, frontend.Listener.Addr().String(), "")
+		time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		start := time.Now()
+		assert.NoError(t, tracker.Drain(ctx), "Expected the socket to finish within the grace period")
+		assert.Less(t, time.Since(start), time.Second, "Drain should return as soon as the sockets are gone")
+	})
+}
@@ -2,17 +2,35 @@ package models
 
 import (
 	"log/slog"
+	"math"
 	"net/url"
 	"sync"
+	"time"
 )
 
 type Backend struct {
 	URL               *url.URL
 	Alive             bool
 	ActiveConnections int
+	Weight            int
+	outlier           outlierState
+	health            healthState
+	latencyEWMA       float64 // nanoseconds
+	latencyStamp      time.Time
+	latencyDecay      time.Duration
 	mux               sync.RWMutex
 }
 
+// outlierState is the passive health bookkeeping kept for outlier detection.
+type outlierState struct {
+	consecutive5xx     int
+	consecutiveGateway int
+	requests           uint64
+	successes          uint64
+	ejectedUntil       time.Time
+	ejections          int
+}
+
 func NewBackend(rawURL string) (*Backend, error) {
 	parsed_url, err := url.Parse(rawURL)
 	if err != nil {
@@ -22,6 +40,7 @@ func NewBackend(rawURL string) (*Backend, error) {
 		URL:               parsed_url,
 		Alive:             true,
 		ActiveConnections: 0,
+		Weight:            1,
 	}, nil
 }
 
@@ -38,6 +57,20 @@ func (b *Backend) SetAlive(alive bool) {
 	b.mux.Unlock()
 }
 
+// SetWeight sets the share of traffic the backend gets relative to the rest of the pool, values below 1 mean 1.
+func (b *Backend) SetWeight(weight int) {
+	b.mux.Lock()
+	b.Weight = max(weight, 1)
+	b.mux.Unlock()
+}
+
+func (b *Backend) GetWeight() int {
+	b.mux.RLock()
+	w := b.Weight
+	b.mux.RUnlock()
+	return max(w, 1)
+}
+
 func (b *Backend) IncrementConnections() {
 	b.mux.Lock()
 	b.ActiveConnections += 1
@@ -57,5 +90,119 @@ func (b *Backend) GetActiveConnections() int {
 	return c
 }
 
+// IsEjected reports whether outlier detection currently keeps the backend out of rotation.
+func (b *Backend) IsEjected() bool {
+	b.mux.RLock()
+	ejected := time.Now().Before(b.outlier.ejectedUntil)
+	b.mux.RUnlock()
+	return ejected
+}
+
+// IsAvailable reports whether the backend can receive traffic: alive per health checks, not flapping and not ejected.
+func (b *Backend) IsAvailable() bool {
+	b.mux.RLock()
+	now := time.Now()
+	available := b.Alive && !now.Before(b.outlier.ejectedUntil) && !now.Before(b.health.heldUntil)
+	b.mux.RUnlock()
+	return available
+}
+
+/*
+RecordOutcome adds one proxied response to the outlier counters and returns the current
+consecutive 5xx and gateway failure streaks. A success resets both streaks.
+*/
+func (b *Backend) RecordOutcome(success, serverError, gatewayError bool) (consecutive5xx, consecutiveGateway int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.outlier.requests++
+	if success {
+		b.outlier.successes++
+	}
+	if serverError {
+		b.outlier.consecutive5xx++
+	} else {
+		b.outlier.consecutive5xx = 0
+	}
+	if gatewayError {
+		b.outlier.consecutiveGateway++
+	} else {
+		b.outlier.consecutiveGateway = 0
+	}
+	return b.outlier.consecutive5xx, b.outlier.consecutiveGateway
+}
+
+// TakeOutlierWindow returns the request and success counts since the last call and starts a new window.
+func (b *Backend) TakeOutlierWindow() (requests, successes uint64) {
+	b.mux.Lock()
+	requests, successes = b.outlier.requests, b.outlier.successes
+	b.outlier.requests, b.outlier.successes = 0, 0
+	b.mux.Unlock()
+	return requests, successes
+}
+
+// Eject takes the backend out of rotation until the given time and bumps its ejection count.
+func (b *Backend) Eject(until time.Time) {
+	b.mux.Lock()
+	b.outlier.ejectedUntil = until
+	b.outlier.ejections++
+	b.outlier.consecutive5xx = 0
+	b.outlier.consecutiveGateway = 0
+	b.mux.Unlock()
+}
+
+func (b *Backend) EjectionCount() int {
+	b.mux.RLock()
+	c := b.outlier.ejections
+	b.mux.RUnlock()
+	return c
+}
+
+// DecayEjections lowers the ejection count of a backend that is back in rotation, shrinking its next ejection period.
+func (b *Backend) DecayEjections() {
+	b.mux.Lock()
+	if b.outlier.ejections > 0 && !time.Now().Before(b.outlier.ejectedUntil) {
+		b.outlier.ejections--
+	}
+	b.mux.Unlock()
+}
+
+/*
+ObserveLatency folds a response time into the backend's peak-EWMA. A sample slower than the average
+replaces it outright, so a backend that suddenly slows down is avoided at once; faster samples pull the
+average down gradually, weighted by how long ago the previous sample was relative to decay. Without
+new samples the average decays towards zero, see LatencyEWMA.
+*/
+func (b *Backend) ObserveLatency(rtt time.Duration, decay time.Duration) {
+	now := time.Now()
+	sample := float64(rtt)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if sample > b.latencyEWMA || b.latencyStamp.IsZero() || decay <= 0 {
+		b.latencyEWMA = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(b.latencyStamp)) / float64(decay))
+		b.latencyEWMA = b.latencyEWMA*w + sample*(1-w)
+	}
+	b.latencyStamp = now
+	b.latencyDecay = decay
+}
+
+/*
+LatencyEWMA returns the peak-EWMA response time, zero until the first response is observed. It decays
+with the time since the last sample, so a backend avoided after a slow answer or a failure looks
+cheaper and cheaper until it gets picked again and a fresh sample says how it really does.
+*/
+func (b *Backend) LatencyEWMA() time.Duration {
+	b.mux.RLock()
+	ewma, stamp, decay := b.latencyEWMA, b.latencyStamp, b.latencyDecay
+	b.mux.RUnlock()
+	if stamp.IsZero() || decay <= 0 {
+		return time.Duration(ewma)
+	}
+	ewma *= math.Exp(-float64(time.Since(stamp)) / float64(decay))
+	// Never decay back to zero, which means no response seen yet
+	return max(time.Duration(ewma), 1)
+}
+
 //TODO:
 /* (b *Backend) UpdateProxy(rawURL string, transport *http.Transport) error */
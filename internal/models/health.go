@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// HealthState is a backend's state as active health checks see it.
+type HealthState string
+
+const (
+	HealthUp   HealthState = "up"
+	HealthDown HealthState = "down"
+	// Held out of rotation for changing state too often, whatever the latest probes say
+	HealthFlapping HealthState = "flapping"
+)
+
+// HealthHistorySize is how many probe results and transitions a backend remembers.
+const HealthHistorySize = 32
+
+// ProbeResult is one active health check of a backend.
+type ProbeResult struct {
+	Time     time.Time
+	Duration time.Duration
+	Passed   bool
+	Reason   string // why it failed: "timeout", "status 503", "body mismatch: ..."
+}
+
+// Transition is a change of a backend's HealthState and the reason for it.
+type Transition struct {
+	Time   time.Time
+	From   HealthState
+	To     HealthState
+	Reason string
+}
+
+/*
+HealthPolicy turns probe results into a HealthState. Rise consecutive passing probes bring a backend
+up and Fall failing ones take it down (values below 1 mean 1). With FlapTransitions and FlapWindow set,
+that many changes between up and down within FlapWindow make the backend flapping: it stays out of
+rotation until FlapHold (FlapWindow when unset) passes without another change.
+*/
+type HealthPolicy struct {
+	Rise            int
+	Fall            int
+	FlapWindow      time.Duration
+	FlapTransitions int
+	FlapHold        time.Duration
+}
+
+type healthState struct {
+	state        HealthState // as last reported in a Transition
+	streakPassed bool
+	streak       int
+	changes      []time.Time // up/down changes inside the flap window
+	heldUntil    time.Time
+	probes       []ProbeResult
+	transitions  []Transition
+}
+
+/*
+RecordProbe adds a probe result to the backend's history and applies it to its state. It returns the
+resulting Transition, nil when the state stayed the same. A flapping backend is reported back up or
+down by the first probe after its hold time.
+*/
+func (b *Backend) RecordProbe(result ProbeResult, policy HealthPolicy) *Transition {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	h := &b.health
+	now := result.Time
+	if h.state == "" {
+		h.state = b.healthStateLocked(now)
+	}
+	h.probes = appendBounded(h.probes, result)
+
+	if h.streakPassed != result.Passed || h.streak == 0 {
+		h.streakPassed, h.streak = result.Passed, 0
+	}
+	h.streak++
+	threshold := max(policy.Fall, 1)
+	if result.Passed {
+		threshold = max(policy.Rise, 1)
+	}
+	reason := result.Reason
+	if b.Alive != result.Passed && h.streak >= threshold {
+		b.Alive = result.Passed
+		switch {
+		case result.Passed && h.streak > 1:
+			reason = fmt.Sprintf("%d consecutive checks passed", h.streak)
+		case result.Passed:
+			reason = "check passed"
+		case h.streak > 1:
+			reason = fmt.Sprintf("%s (%d consecutive checks failed)", result.Reason, h.streak)
+		}
+		if policy.FlapTransitions > 0 && policy.FlapWindow > 0 {
+			h.changes = slices.DeleteFunc(h.changes, func(t time.Time) bool {
+				return now.Sub(t) >= policy.FlapWindow
+			})
+			// Only the latest FlapTransitions changes can still add up to flapping
+			if len(h.changes) >= policy.FlapTransitions {
+				h.changes = slices.Delete(h.changes, 0, len(h.changes)-policy.FlapTransitions+1)
+			}
+			h.changes = append(h.changes, now)
+			if len(h.changes) >= policy.FlapTransitions {
+				hold := policy.FlapHold
+				if hold <= 0 {
+					hold = policy.FlapWindow
+				}
+				h.heldUntil = now.Add(hold)
+				reason = fmt.Sprintf("%d state changes within %s", len(h.changes), policy.FlapWindow)
+			}
+		}
+	}
+
+	state := b.healthStateLocked(now)
+	if state == h.state {
+		return nil
+	}
+	if h.state == HealthFlapping {
+		reason = "stopped flapping"
+	}
+	t := Transition{Time: now, From: h.state, To: state, Reason: reason}
+	h.state = state
+	h.transitions = appendBounded(h.transitions, t)
+	return &t
+}
+
+func (b *Backend) healthStateLocked(now time.Time) HealthState {
+	switch {
+	case now.Before(b.health.heldUntil):
+		return HealthFlapping
+	case b.Alive:
+		return HealthUp
+	}
+	return HealthDown
+}
+
+// HealthState returns the backend's current state.
+func (b *Backend) HealthState() HealthState {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.healthStateLocked(time.Now())
+}
+
+// HealthHistory returns the latest probe results and transitions, oldest first.
+func (b *Backend) HealthHistory() (probes []ProbeResult, transitions []Transition) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return slices.Clone(b.health.probes), slices.Clone(b.health.transitions)
+}
+
+// appendBounded appends v, dropping the oldest entry once HealthHistorySize is reached.
+func appendBounded[T any](s []T, v T) []T {
+	if len(s) < HealthHistorySize {
+		return append(s, v)
+	}
+	copy(s, s[1:])
+	s[len(s)-1] = v
+	return s
+}
@@ -1,8 +1,17 @@
 package models
 
+import "sync"
+
+/*
+ServerPool is the set of backends of a pool. Membership changes replace Backends with a new slice, so
+the slice returned by AllBackends can be iterated without locking while backends come and go.
+*/
 type ServerPool struct {
 	Backends     []*Backend
 	CurrentIndex uint64
+
+	mux     sync.RWMutex
+	changed chan struct{}
 }
 
 func NewServerPool() *ServerPool {
@@ -10,23 +19,77 @@ func NewServerPool() *ServerPool {
 	return &ServerPool{
 		Backends:     backends,
 		CurrentIndex: 0,
+		changed:      make(chan struct{}),
 	}
 }
 
 func (s *ServerPool) AddBackend(backend *Backend) {
-	s.Backends = append(s.Backends, backend)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.Backends = append(s.Backends[:len(s.Backends):len(s.Backends)], backend)
+	s.notify()
+}
+
+// RemoveBackend takes backend out of the pool, reporting whether it was in it.
+func (s *ServerPool) RemoveBackend(backend *Backend) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, b := range s.Backends {
+		if b == backend {
+			backends := make([]*Backend, 0, len(s.Backends)-1)
+			s.Backends = append(append(backends, s.Backends[:i]...), s.Backends[i+1:]...)
+			s.notify()
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes up everyone waiting on Changed, the caller holds the lock.
+func (s *ServerPool) notify() {
+	if s.changed != nil {
+		close(s.changed)
+	}
+	s.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed on the next membership change.
+func (s *ServerPool) Changed() <-chan struct{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// AllBackends returns every member of the pool, available or not. The slice must not be modified.
+func (sp *ServerPool) AllBackends() []*Backend {
+	sp.mux.RLock()
+	defer sp.mux.RUnlock()
+	return sp.Backends
 }
 
 func (sp *ServerPool) GetBackends() []*Backend {
 	var backends []*Backend
-	for _, b := range sp.Backends {
-		if b.IsAlive() {
+	for _, b := range sp.AllBackends() {
+		if b.IsAvailable() {
 			backends = append(backends, b)
 		}
 	}
 	return backends
 }
 
+func (sp *ServerPool) GetEjectedCount() int {
+	count := 0
+	for _, b := range sp.AllBackends() {
+		if b.IsEjected() {
+			count++
+		}
+	}
+	return count
+}
+
 func (sp *ServerPool) GetBackendCount() int {
-	return len(sp.Backends)
+	return len(sp.AllBackends())
 }
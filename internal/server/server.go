@@ -2,33 +2,196 @@ package server
 
 import (
 	handler "GoRelay/internal/loadbalancer/delivery"
+	"GoRelay/pkg/clientip"
 	"GoRelay/pkg/logger"
+	"GoRelay/pkg/metrics"
+	"GoRelay/pkg/proxyproto"
 	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
+	"slices"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
+// Server serves the routes on any number of listeners, plain or TLS, and shuts them down together.
 type Server struct {
 	srv    *http.Server
 	routes *handler.RouteConfig
 	logger *logger.Logger
+
+	mux          sync.Mutex
+	listeners    []*http.Server
+	tcpListeners []*tcpListener
+	udpListeners []*udpListener
+}
+
+type Option func(*Server)
+
+// WithH2C lets the port listener accept cleartext HTTP/2 (prior knowledge or Upgrade: h2c), as gRPC clients without TLS use.
+func WithH2C(enabled bool) Option {
+	return func(s *Server) {
+		if enabled {
+			s.srv.Handler = h2cHandler(s.routes.GetMux())
+		}
+	}
 }
 
-func NewServer(routes *handler.RouteConfig, logger *logger.Logger) *Server {
+func NewServer(routes *handler.RouteConfig, logger *logger.Logger, opts ...Option) *Server {
 	srv := &http.Server{
 		Handler: routes.GetMux(),
 	}
-	return &Server{
-		srv:    srv,
-		routes: routes,
-		logger: logger,
+	s := &Server{
+		srv:       srv,
+		routes:    routes,
+		logger:    logger,
+		listeners: []*http.Server{srv},
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type listenConfig struct {
+	proxyProtocol *clientip.Resolver
 }
 
-func (s *Server) Start(port string) error {
+// ListenOption configures a single listener, of any kind but UDP.
+type ListenOption func(*listenConfig)
+
+/*
+WithProxyProtocol expects a PROXY protocol header (v1 or v2) from the peers trusted by resolver, and
+takes the client address from it. Other peers are served as usual.
+*/
+func WithProxyProtocol(resolver *clientip.Resolver) ListenOption {
+	return func(c *listenConfig) {
+		c.proxyProtocol = resolver
+	}
+}
+
+func listen(addr string, opts []ListenOption) (net.Listener, error) {
+	var cfg listenConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.proxyProtocol != nil {
+		ln = proxyproto.NewListener(ln, cfg.proxyProtocol)
+	}
+	return ln, nil
+}
+
+func h2cHandler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+func (s *Server) Start(port string, opts ...ListenOption) error {
 	s.srv.Addr = ":" + port
-	return s.srv.ListenAndServe()
+	ln, err := listen(s.srv.Addr, opts)
+	if err != nil {
+		return err
+	}
+	return s.srv.Serve(ln)
+}
+
+// Listen serves plain HTTP on an extra address until Shutdown, cleartext HTTP/2 included when h2c is set.
+func (s *Server) Listen(addr string, h2c bool, opts ...ListenOption) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.routes.GetMux(),
+	}
+	if h2c {
+		srv.Handler = h2cHandler(srv.Handler)
+	}
+	ln, err := listen(addr, opts)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.listeners = append(s.listeners, srv)
+	s.mux.Unlock()
+	return srv.Serve(ln)
+}
+
+// ServeMetrics serves the metrics on /metrics of addr until Shutdown, apart from the proxied traffic.
+func (s *Server) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	ln, err := listen(addr, nil)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.listeners = append(s.listeners, srv)
+	s.mux.Unlock()
+	return srv.Serve(ln)
+}
+
+/*
+StartTLS serves HTTPS on addr until Shutdown. Certificates come from tlsConfig (GetCertificate or
+Certificates), so no files are passed to ServeTLS. HTTP/2 is offered through ALPN when
+tlsConfig.NextProtos lists "h2".
+*/
+func (s *Server) StartTLS(addr string, tlsConfig *tls.Config, opts ...ListenOption) error {
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   s.routes.GetMux(),
+		TLSConfig: tlsConfig,
+	}
+	if !slices.Contains(tlsConfig.NextProtos, "h2") {
+		// A non-nil empty map keeps net/http from enabling HTTP/2 on its own
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	ln, err := listen(addr, opts)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	s.listeners = append(s.listeners, srv)
+	s.mux.Unlock()
+	return srv.ServeTLS(ln, "", "")
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.srv.Shutdown(ctx)
+	s.mux.Lock()
+	listeners, tcpListeners, udpListeners := s.listeners, s.tcpListeners, s.udpListeners
+	s.mux.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners)+len(tcpListeners)+len(udpListeners))
+	for i, srv := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	for i, l := range tcpListeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[len(listeners)+i] = l.shutdown(ctx)
+		}()
+	}
+	for i, l := range udpListeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[len(listeners)+len(tcpListeners)+i] = l.shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
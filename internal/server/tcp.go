@@ -0,0 +1,108 @@
+package server
+
+import (
+	"GoRelay/pkg/proxyproto"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConnHandler serves one accepted connection. ctx is cancelled once a Shutdown deadline passes.
+type ConnHandler interface {
+	HandleConn(ctx context.Context, conn net.Conn) error
+}
+
+// tcpListener accepts raw connections and keeps count of them, so Shutdown can wait for them to drain.
+type tcpListener struct {
+	ln      net.Listener
+	handler ConnHandler
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mux    sync.Mutex
+	closed bool
+	conns  sync.WaitGroup
+}
+
+/*
+ServeTCP accepts raw TCP connections on addr and hands each one to h, until Shutdown. Like the HTTP
+listeners it returns http.ErrServerClosed then.
+*/
+func (s *Server) ServeTCP(addr string, h ConnHandler, opts ...ListenOption) error {
+	ln, err := listen(addr, opts)
+	if err != nil {
+		return err
+	}
+	return s.serveTCP(ln, h)
+}
+
+func (s *Server) serveTCP(ln net.Listener, h ConnHandler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &tcpListener{ln: ln, handler: h, ctx: ctx, cancel: cancel}
+	s.mux.Lock()
+	s.tcpListeners = append(s.tcpListeners, l)
+	s.mux.Unlock()
+
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return http.ErrServerClosed
+		}
+		if err != nil {
+			// Out of file descriptors and the like: back off as net/http does instead of spinning
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			s.logger.Warn("tcp accept failed", "address", ln.Addr().String(), "error", err, "retry_in", backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		l.mux.Lock()
+		if l.closed {
+			l.mux.Unlock()
+			conn.Close()
+			return http.ErrServerClosed
+		}
+		l.conns.Add(1)
+		l.mux.Unlock()
+		go func() {
+			defer l.conns.Done()
+			if pc, ok := conn.(*proxyproto.Conn); ok {
+				// Read the header up front, so the handler sees the real client and bad peers are dropped
+				if _, err := pc.Header(); err != nil {
+					s.logger.Warn("dropping TCP connection without a valid PROXY header", "address", ln.Addr().String(), "peer", pc.Conn.RemoteAddr().String(), "error", err)
+					conn.Close()
+					return
+				}
+			}
+			if err := h.HandleConn(ctx, conn); err != nil {
+				s.logger.Error("Error while serving TCP connection", "address", ln.Addr().String(), "client", conn.RemoteAddr().String(), "error", err)
+			}
+		}()
+	}
+}
+
+// shutdown stops accepting and waits for the open connections to finish, cutting them off when ctx ends.
+func (l *tcpListener) shutdown(ctx context.Context) error {
+	l.mux.Lock()
+	l.closed = true
+	l.mux.Unlock()
+	err := l.ln.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		l.conns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return err
+	case <-ctx.Done():
+		l.cancel()
+		<-drained
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	handler "GoRelay/internal/loadbalancer/delivery"
+	"GoRelay/pkg/clientip"
+	"GoRelay/pkg/logger"
+	"GoRelay/pkg/proxyproto"
+	"GoRelay/pkg/utils"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoHandler copies the connection back to itself until the client or the server's shutdown ends it.
+type echoHandler struct{}
+
+func (echoHandler) HandleConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	io.Copy(conn, conn)
+	return nil
+}
+
+func TestServeTCP(t *testing.T) {
+	newServer := func(t *testing.T) (*Server, string, <-chan error) {
+		s := NewServer(handler.NewRouteConfig(nil), logger.NewLogger())
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		served := make(chan error, 1)
+		go func() { served <- s.serveTCP(ln, echoHandler{}) }()
+		return s, ln.Addr().String(), served
+	}
+
+	t.Run("DrainsOpenConnections", func(t *testing.T) {
+		s, addr, served := newServer(t)
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		conn.Write([]byte("hi"))
+		buf := make([]byte, 2)
+		io.ReadFull(conn, buf)
+		assert.Equal(t, "hi", string(buf), "Expected the connection to be served")
+
+		// The client leaves during the grace period
+		time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, s.Shutdown(ctx), "Expected the connection to finish within the grace period")
+		assert.ErrorIs(t, <-served, http.ErrServerClosed)
+
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err, "Expected the listener to be closed")
+	})
+
+	t.Run("CutsOffAfterDeadline", func(t *testing.T) {
+		s, addr, _ := newServer(t)
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		conn.Write([]byte("hi"))
+		io.ReadFull(conn, make([]byte, 2))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded, "Expected the open connection to outlive the deadline")
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "Expected the connection to be closed")
+	})
+}
+
+// addrHandler answers with the client address it was handed.
+type addrHandler struct{}
+
+func (addrHandler) HandleConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	_, err := io.WriteString(conn, conn.RemoteAddr().String())
+	return err
+}
+
+func TestServeTCPProxyProtocol(t *testing.T) {
+	s := NewServer(handler.NewRouteConfig(nil), logger.NewLogger())
+	trusted, _ := clientip.NewResolver([]string{"127.0.0.1"})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go s.serveTCP(proxyproto.NewListener(ln, trusted), addrHandler{})
+	defer s.Shutdown(context.Background())
+
+	dial := func(header string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, header)
+		conn.SetReadDeadline(time.Now().Add(2 * proxyproto.DefaultHeaderTimeout))
+		reply, _ := io.ReadAll(conn)
+		return string(reply)
+	}
+	assert.Equal(t, "198.51.100.4:1234", dial("PROXY TCP4 198.51.100.4 10.0.0.1 1234 5432\r\n"), "Expected the handler to see the client from the header")
+	assert.Empty(t, dial("SELECT 1;\r\n"), "Expected a connection without a header to be dropped")
+}
+
+// algorithms stands in for usecase.BalancerNames in config validation.
+var algorithms = []string{"round_robin"}
+
+func TestTCPListenerValidation(t *testing.T) {
+	cfg := &utils.Config{
+		HealthInterval: 1,
+		Algorithm:      "round_robin",
+		Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+		Pools: map[string]utils.PoolConfig{
+			"postgres": {Backends: []utils.BackendConfig{{URL: "tcp://10.0.0.1:5432"}, {URL: "tcp://10.0.0.2:5432"}}},
+		},
+		Listeners: []utils.ListenerConfig{{Address: ":5432", Protocol: "tcp", Pool: "postgres"}},
+	}
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected a TCP listener over tcp:// backends to be valid")
+
+	cfg.Listeners[0].Pool = ""
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected HTTP backends to be rejected for a TCP listener")
+	cfg.Listeners[0].Pool = "redis"
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected an unknown pool to be rejected")
+	cfg.Listeners[0].Pool = "postgres"
+	cfg.Listeners[0].H2C = true
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected HTTP settings to be rejected for a TCP listener")
+
+	cfg.Listeners[0] = utils.ListenerConfig{Address: ":53", Protocol: "udp", Pool: "postgres"}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected tcp:// backends to be rejected for a UDP listener")
+	cfg.Pools["dns"] = utils.PoolConfig{Backends: []utils.BackendConfig{{URL: "udp://10.0.0.53:53"}}}
+	cfg.Listeners[0].Pool = "dns"
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected a UDP listener over udp:// backends to be valid")
+	cfg.Listeners[0].MaxSessions = 100
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected a session limit on a UDP listener to be valid")
+	cfg.Listeners[0].ProxyProtocol = []string{"10.0.0.0/8"}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected PROXY protocol to be rejected for a UDP listener")
+
+	cfg.Listeners[0] = utils.ListenerConfig{Address: ":5432", Protocol: "tcp", Pool: "postgres", ProxyProtocol: []string{"10.0.0.0/8"}, SendProxyProtocol: "v2"}
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected PROXY protocol both ways on a TCP listener to be valid")
+	cfg.Listeners[0].MaxSessions = 100
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected a session limit to be rejected for a TCP listener")
+	cfg.Listeners[0] = utils.ListenerConfig{Address: ":8443", SendProxyProtocol: "v1"}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected sending PROXY protocol to be rejected for an HTTP listener")
+	cfg.Listeners[0] = utils.ListenerConfig{Address: ":8443", ProxyProtocol: []string{"not-a-cidr"}}
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected invalid PROXY protocol sources to be rejected")
+
+	// Unrouted HTTP requests go to the default pool, which can't be raw then
+	cfg.Backends = []utils.BackendConfig{{URL: "tcp://10.0.0.3:5432"}}
+	cfg.Listeners = []utils.ListenerConfig{{Address: ":5432", Protocol: "tcp"}}
+	assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected raw top-level backends behind a TCP listener to be valid")
+	cfg.Port = "8080"
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected an HTTP port over tcp:// backends to be rejected")
+	cfg.Port = ""
+	cfg.Listeners = append(cfg.Listeners, utils.ListenerConfig{Address: ":8443"})
+	assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected an HTTP listener over tcp:// backends to be rejected")
+}
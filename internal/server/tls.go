@@ -0,0 +1,110 @@
+package server
+
+import (
+	"GoRelay/pkg/certs"
+	"GoRelay/pkg/metrics"
+	"GoRelay/pkg/utils"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const DefaultCertReloadInterval = 10 * time.Second
+
+var rejectedHandshakes = metrics.Default.NewCounterVec("gorelay_tls_client_cert_rejected_total",
+	"TLS handshakes refused because of the client certificate.", "listener", "reason")
+
+/*
+NewTLSConfig builds the tls.Config of an HTTPS listener. The returned store serves the certificates
+and must be watched (Store.Watch) for rotated files to be picked up. Client certificate rejections are
+counted under the listener's name.
+*/
+func NewTLSConfig(listener string, cfg utils.TLSConfig) (*tls.Config, *certs.Store, error) {
+	pairs := make([]certs.Pair, 0, len(cfg.Certificates))
+	for _, c := range cfg.Certificates {
+		pairs = append(pairs, certs.Pair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+	store, err := certs.NewStore(pairs)
+	if err != nil {
+		return nil, nil, err
+	}
+	minVersion, err := certs.ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphers, err := certs.ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	nextProtos := []string{"h2", "http/1.1"}
+	if cfg.DisableHTTP2 {
+		nextProtos = []string{"http/1.1"}
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   ciphers,
+		NextProtos:     nextProtos,
+	}
+	if err := setClientAuth(tlsConfig, listener, cfg.ClientAuth); err != nil {
+		return nil, nil, err
+	}
+	return tlsConfig, store, nil
+}
+
+/*
+setClientAuth makes the listener ask for client certificates. crypto/tls would verify them itself,
+but then refused handshakes never reach our code; so certificates are only requested and the
+verification happens in VerifyConnection, where failures can be counted.
+*/
+func setClientAuth(tlsConfig *tls.Config, listener string, cfg utils.ClientAuthConfig) error {
+	if cfg.Mode == "" || cfg.Mode == "none" {
+		return nil
+	}
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return fmt.Errorf("reading client CA bundle: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", cfg.CAFile)
+	}
+	required := cfg.Mode == "require"
+
+	tlsConfig.ClientAuth = tls.RequestClientCert
+	tlsConfig.ClientCAs = roots // only advertised to the client as acceptable issuers
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			if required {
+				rejectedHandshakes.With(listener, "missing").Inc()
+				return errors.New("tls: client certificate required")
+			}
+			return nil
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+			rejectedHandshakes.With(listener, "invalid").Inc()
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+// ReloadInterval returns how often the listener's certificate files are checked for changes.
+func ReloadInterval(cfg utils.TLSConfig) time.Duration {
+	if cfg.ReloadInterval > 0 {
+		return cfg.ReloadInterval
+	}
+	return DefaultCertReloadInterval
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"GoRelay/pkg/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCert(t *testing.T, name string) utils.CertificateConfig {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	cfg := utils.CertificateConfig{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cfg
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certs := []utils.CertificateConfig{writeCert(t, "a.example.com"), writeCert(t, "b.example.com")}
+
+	tests := []struct {
+		name      string
+		cfg       utils.TLSConfig
+		clientMax uint16
+		alpn      []string
+		proto     string
+		fails     bool
+	}{
+		{name: "NegotiatesH2", cfg: utils.TLSConfig{Certificates: certs}, alpn: []string{"h2", "http/1.1"}, proto: "h2"},
+		{name: "HTTP2Disabled", cfg: utils.TLSConfig{Certificates: certs, DisableHTTP2: true}, alpn: []string{"h2", "http/1.1"}, proto: "http/1.1"},
+		{name: "MinVersionRejectsOldClients", cfg: utils.TLSConfig{Certificates: certs, MinVersion: "1.3"}, clientMax: tls.VersionTLS12, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, _, err := NewTLSConfig("test", tt.cfg)
+			assert.NoError(t, err, "Expected a valid TLS config")
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+			assert.NoError(t, err)
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err == nil {
+					conn.(*tls.Conn).Handshake()
+					conn.Close()
+				}
+			}()
+
+			pool := x509.NewCertPool()
+			for _, c := range certs {
+				pem, _ := os.ReadFile(c.CertFile)
+				pool.AppendCertsFromPEM(pem)
+			}
+			conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+				ServerName: "b.example.com",
+				RootCAs:    pool,
+				NextProtos: tt.alpn,
+				MaxVersion: tt.clientMax,
+			})
+			if tt.fails {
+				assert.Error(t, err, "Expected the handshake to fail")
+				return
+			}
+			assert.NoError(t, err, "Expected the handshake to succeed")
+			defer conn.Close()
+			state := conn.ConnectionState()
+			assert.Equal(t, "b.example.com", state.PeerCertificates[0].Subject.CommonName, "Expected the certificate chosen by SNI")
+			assert.Equal(t, tt.proto, state.NegotiatedProtocol, "Unexpected ALPN protocol")
+		})
+	}
+
+	t.Run("ListenerValidation", func(t *testing.T) {
+		cfg := &utils.Config{
+			HealthInterval: 1,
+			Algorithm:      "round_robin",
+			Backends:       []utils.BackendConfig{{URL: "http://localhost:9001"}},
+		}
+		assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected a port or a listener to be required")
+		cfg.Listeners = []utils.ListenerConfig{{Address: ":8443", TLS: &utils.TLSConfig{Certificates: certs, MinVersion: "1.2"}}}
+		assert.NoError(t, utils.ValidateConfig(cfg, algorithms), "Expected a TLS listener to be enough")
+		cfg.Listeners[0].TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+		assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected an insecure cipher suite to be rejected")
+		cfg.Listeners[0].TLS = &utils.TLSConfig{}
+		assert.Error(t, utils.ValidateConfig(cfg, algorithms), "Expected certificates to be required")
+	})
+}
+
+func TestClientAuth(t *testing.T) {
+	serverCerts := []utils.CertificateConfig{writeCert(t, "gorelay.example.com")}
+	trusted := writeCert(t, "billing")
+	stranger := writeCert(t, "stranger")
+
+	tests := []struct {
+		name   string
+		mode   string
+		client *utils.CertificateConfig
+		reason string // rejection reason counted, empty when the handshake succeeds
+	}{
+		{name: "RequireWithTrustedCert", mode: "require", client: &trusted},
+		{name: "RequireWithoutCert", mode: "require", reason: "missing"},
+		{name: "RequireWithUnknownCert", mode: "require", client: &stranger, reason: "invalid"},
+		{name: "OptionalWithoutCert", mode: "optional"},
+		{name: "OptionalWithUnknownCert", mode: "optional", client: &stranger, reason: "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := "auth-" + tt.name
+			tlsConfig, _, err := NewTLSConfig(listener, utils.TLSConfig{
+				Certificates: serverCerts,
+				ClientAuth:   utils.ClientAuthConfig{Mode: tt.mode, CAFile: trusted.CertFile},
+			})
+			assert.NoError(t, err, "Expected a valid TLS config")
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+			assert.NoError(t, err)
+			defer ln.Close()
+			result := make(chan error, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					result <- err
+					return
+				}
+				defer conn.Close()
+				result <- conn.(*tls.Conn).Handshake()
+			}()
+
+			clientConfig := &tls.Config{InsecureSkipVerify: true}
+			if tt.client != nil {
+				cert, err := tls.LoadX509KeyPair(tt.client.CertFile, tt.client.KeyFile)
+				assert.NoError(t, err)
+				// Presented even when its issuer is not among the CAs the server advertises
+				clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+			if err == nil {
+				defer conn.Close()
+			}
+
+			serverErr := <-result
+			if tt.reason == "" {
+				assert.NoError(t, serverErr, "Expected the handshake to succeed")
+				return
+			}
+			assert.Error(t, serverErr, "Expected the handshake to be refused")
+			assert.Equal(t, float64(1), rejectedHandshakes.With(listener, tt.reason).Value(), "Expected the rejection to be counted")
+		})
+	}
+
+	t.Run("MissingCAFile", func(t *testing.T) {
+		_, _, err := NewTLSConfig("auth", utils.TLSConfig{Certificates: serverCerts, ClientAuth: utils.ClientAuthConfig{Mode: "require"}})
+		assert.Error(t, err, "Expected the CA bundle to be required")
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// PacketHandler serves the datagrams of a UDP listener until conn is closed or ctx ends.
+type PacketHandler interface {
+	ServePackets(ctx context.Context, conn net.PacketConn) error
+}
+
+type udpListener struct {
+	conn   net.PacketConn
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ServeUDP hands the datagrams received on addr to h until Shutdown, returning http.ErrServerClosed then.
+func (s *Server) ServeUDP(addr string, h PacketHandler) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serveUDP(conn, h)
+}
+
+func (s *Server) serveUDP(conn net.PacketConn, h PacketHandler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &udpListener{conn: conn, cancel: cancel, done: make(chan struct{})}
+	s.mux.Lock()
+	s.udpListeners = append(s.udpListeners, l)
+	s.mux.Unlock()
+
+	defer close(l.done)
+	if err := h.ServePackets(ctx, conn); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return http.ErrServerClosed
+}
+
+// shutdown closes the socket right away: datagrams have no connection to finish, sessions just end.
+func (l *udpListener) shutdown(ctx context.Context) error {
+	l.cancel()
+	err := l.conn.Close()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	handler "GoRelay/internal/loadbalancer/delivery"
+	"GoRelay/pkg/logger"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type udpEchoHandler struct{}
+
+func (udpEchoHandler) ServePackets(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
+
+func TestServeUDP(t *testing.T) {
+	s := NewServer(handler.NewRouteConfig(nil), logger.NewLogger())
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- s.serveUDP(conn, udpEchoHandler{}) }()
+
+	client, _ := net.Dial("udp", conn.LocalAddr().String())
+	defer client.Close()
+	client.Write([]byte("hi"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 2)
+	_, err = client.Read(buf)
+	assert.NoError(t, err, "Expected the datagram to be served")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx), "Expected the socket to close right away")
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+}
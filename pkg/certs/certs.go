@@ -0,0 +1,183 @@
+/*
+Package certs loads certificate/key pairs for TLS listeners, picks one per handshake by SNI and
+reloads them when the files on disk change, so certificates can be rotated without a restart.
+*/
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Pair names the files of one certificate, CertFile may hold the full chain.
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+type loaded struct {
+	pair    Pair
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// Store holds the current certificates. It is safe for concurrent use by handshakes and Watch.
+type Store struct {
+	pairs   []Pair
+	current atomic.Pointer[[]loaded]
+}
+
+// NewStore loads every pair once, failing if any of them can't be used.
+func NewStore(pairs []Pair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("certs: no certificates configured")
+	}
+	s := &Store{pairs: pairs}
+	certs := make([]loaded, 0, len(pairs))
+	for _, p := range pairs {
+		l, err := load(p)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, l)
+	}
+	s.current.Store(&certs)
+	return s, nil
+}
+
+func load(p Pair) (loaded, error) {
+	cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+	if err != nil {
+		return loaded{}, fmt.Errorf("certs: loading %s: %w", p.CertFile, err)
+	}
+	return loaded{pair: p, cert: &cert, modTime: modTime(p)}, nil
+}
+
+// modTime is the later of both files' modification times, zero when either can't be read.
+func modTime(p Pair) time.Time {
+	var latest time.Time
+	for _, name := range []string{p.CertFile, p.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+/*
+GetCertificate is meant for tls.Config.GetCertificate. It returns the first certificate, in
+configuration order, that is valid for the client's SNI name and signature schemes, and the first
+certificate when none is (or the client sent no SNI).
+*/
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.current.Load()
+	if hello.ServerName != "" {
+		for _, l := range certs {
+			if hello.SupportsCertificate(l.cert) == nil {
+				return l.cert, nil
+			}
+		}
+	}
+	return certs[0].cert, nil
+}
+
+/*
+Reload re-reads the pairs whose files changed since they were last loaded. A pair that fails to load
+keeps serving its previous certificate, so a half-written rotation does not take the listener down;
+the errors are returned joined.
+*/
+func (s *Store) Reload() (bool, error) {
+	old := *s.current.Load()
+	certs := make([]loaded, len(old))
+	copy(certs, old)
+	changed := false
+	var errs []error
+	for i, l := range certs {
+		mt := modTime(l.pair)
+		if mt.IsZero() || mt.Equal(l.modTime) {
+			continue
+		}
+		fresh, err := load(l.pair)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		certs[i] = fresh
+		changed = true
+	}
+	if changed {
+		s.current.Store(&certs)
+	}
+	return changed, errors.Join(errs...)
+}
+
+// Watch polls the files every interval until ctx is done; onError, if set, gets reload failures.
+func (s *Store) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ParseVersion maps "1.0" to "1.3" onto the crypto/tls constants, an empty string gives TLS 1.2.
+func ParseVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(v), "tls") {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("certs: unknown TLS version %q", v)
+}
+
+/*
+ParseCipherSuites maps IANA names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 to their IDs. Only
+the suites crypto/tls considers secure are accepted. TLS 1.3 suites are not configurable in Go and
+are rejected as well. An empty list gives nil, the crypto/tls defaults.
+*/
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		for _, v := range cs.SupportedVersions {
+			if v < tls.VersionTLS13 {
+				known[cs.Name] = cs.ID
+			}
+		}
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("certs: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
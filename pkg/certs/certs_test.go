@@ -0,0 +1,141 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePair writes a self-signed certificate for names into dir and returns its files.
+func writePair(t *testing.T, dir, prefix string, names ...string) Pair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	p := Pair{CertFile: filepath.Join(dir, prefix+".crt"), KeyFile: filepath.Join(dir, prefix+".key")}
+	assert.NoError(t, os.WriteFile(p.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(p.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return p
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	api := writePair(t, dir, "api", "api.example.com")
+	wildcard := writePair(t, dir, "wildcard", "www.example.com", "*.example.com")
+	store, err := NewStore([]Pair{api, wildcard})
+	assert.NoError(t, err, "Expected the certificates to load")
+
+	hello := func(name string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{
+			ServerName:        name,
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SupportedCurves:   []tls.CurveID{tls.CurveP256},
+			CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		serverName string
+		expected   string
+	}{
+		{name: "ExactName", serverName: "api.example.com", expected: "api.example.com"},
+		{name: "Wildcard", serverName: "shop.example.com", expected: "www.example.com"},
+		{name: "UnknownNameGetsFirst", serverName: "other.test", expected: "api.example.com"},
+		{name: "NoSNIGetsFirst", serverName: "", expected: "api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := store.GetCertificate(hello(tt.serverName))
+			assert.NoError(t, err, "Expected a certificate")
+			assert.Equal(t, tt.expected, commonName(t, cert), "Wrong certificate for %q", tt.serverName)
+		})
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		changed, err := store.Reload()
+		assert.NoError(t, err, "Expected no error")
+		assert.False(t, changed, "Nothing changed on disk")
+
+		rotated := writePair(t, t.TempDir(), "rotated", "api2.example.com")
+		for src, dst := range map[string]string{rotated.CertFile: api.CertFile, rotated.KeyFile: api.KeyFile} {
+			data, _ := os.ReadFile(src)
+			assert.NoError(t, os.WriteFile(dst, data, 0o600))
+			later := time.Now().Add(time.Minute)
+			assert.NoError(t, os.Chtimes(dst, later, later))
+		}
+		changed, err = store.Reload()
+		assert.NoError(t, err, "Expected no error")
+		assert.True(t, changed, "Expected the rotated certificate to be picked up")
+		cert, _ := store.GetCertificate(hello(""))
+		assert.Equal(t, "api2.example.com", commonName(t, cert), "Expected the new certificate")
+	})
+
+	t.Run("BrokenRotationKeepsOldCertificate", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(api.CertFile, []byte("half written"), 0o600))
+		later := time.Now().Add(2 * time.Minute)
+		assert.NoError(t, os.Chtimes(api.CertFile, later, later))
+		changed, err := store.Reload()
+		assert.Error(t, err, "Expected the broken file to be reported")
+		assert.False(t, changed, "Expected nothing to be swapped")
+		cert, _ := store.GetCertificate(hello(""))
+		assert.Equal(t, "api2.example.com", commonName(t, cert), "Expected the previous certificate to stay")
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := NewStore([]Pair{{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: api.KeyFile}})
+		assert.Error(t, err, "Expected a missing file to fail")
+	})
+}
+
+func TestParse(t *testing.T) {
+	v, err := ParseVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v, "Expected TLS 1.3")
+	v, err = ParseVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v, "Expected the TLS 1.2 default")
+	_, err = ParseVersion("1.4")
+	assert.Error(t, err, "Expected an unknown version to fail")
+
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, ids, "Expected the suite ID")
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.Error(t, err, "Expected an insecure suite to be rejected")
+	_, err = ParseCipherSuites([]string{"TLS_AES_128_GCM_SHA256"})
+	assert.Error(t, err, "Expected a TLS 1.3 suite to be rejected")
+}
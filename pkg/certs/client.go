@@ -0,0 +1,50 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ClientOptions describes the TLS side of connections GoRelay opens to backends.
+type ClientOptions struct {
+	CAFile             string // PEM bundle replacing the system roots, system roots when empty
+	Client             *Store // client certificate for mTLS, nil for none
+	ServerName         string // verified and sent as SNI instead of the backend host
+	InsecureSkipVerify bool
+	MinVersion         string
+}
+
+func NewClientConfig(opts ClientOptions) (*tls.Config, error) {
+	minVersion, err := ParseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		MinVersion:         minVersion,
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("certs: reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("certs: no certificates found in " + opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.Client != nil {
+		cfg.GetClientCertificate = opts.Client.GetClientCertificate
+	}
+	return cfg, nil
+}
+
+// GetClientCertificate is meant for tls.Config.GetClientCertificate, it always presents the first certificate.
+func (s *Store) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return (*s.current.Load())[0].cert, nil
+}
@@ -0,0 +1,73 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	serverPair := writePair(t, dir, "server", "backend.internal")
+	clientPair := writePair(t, dir, "client", "gorelay")
+
+	serverCert, err := tls.LoadX509KeyPair(serverPair.CertFile, serverPair.KeyFile)
+	assert.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientPEM, _ := os.ReadFile(clientPair.CertFile)
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	client, err := NewStore([]Pair{clientPair})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		opts     ClientOptions
+		expected string
+	}{
+		{name: "PrivateCAAndClientCertificate", opts: ClientOptions{CAFile: serverPair.CertFile, Client: client, ServerName: "backend.internal"}, expected: "gorelay"},
+		{name: "WrongServerName", opts: ClientOptions{CAFile: serverPair.CertFile, Client: client, ServerName: "other.internal"}},
+		{name: "UnknownCA", opts: ClientOptions{Client: client, ServerName: "backend.internal"}},
+		{name: "NoClientCertificate", opts: ClientOptions{CAFile: serverPair.CertFile, ServerName: "backend.internal"}},
+		{name: "InsecureSkipVerify", opts: ClientOptions{Client: client, InsecureSkipVerify: true}, expected: "gorelay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewClientConfig(tt.opts)
+			assert.NoError(t, err, "Expected a valid client config")
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			resp, err := c.Get(upstream.URL)
+			if tt.expected == "" {
+				assert.Error(t, err, "Expected the connection to fail")
+				return
+			}
+			assert.NoError(t, err, "Expected the connection to succeed")
+			defer resp.Body.Close()
+			body := make([]byte, 64)
+			n, _ := resp.Body.Read(body)
+			assert.Equal(t, tt.expected, string(body[:n]), "Expected the client certificate to reach the backend")
+		})
+	}
+
+	t.Run("BadCAFile", func(t *testing.T) {
+		_, err := NewClientConfig(ClientOptions{CAFile: serverPair.KeyFile})
+		assert.Error(t, err, "Expected a bundle without certificates to fail")
+	})
+}
@@ -0,0 +1,143 @@
+/*
+Package clientip works out the address of the client behind a request. Proxies listed as trusted are
+allowed to report the client in X-Forwarded-For (or the RFC 7239 Forwarded header); everybody else is
+taken at face value, so a client can't spoof its address by sending those headers itself.
+*/
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver resolves the real client address of requests. The zero value trusts no proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver accepts CIDRs ("10.0.0.0/8") and single addresses ("192.168.1.10").
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, s := range trustedProxies {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// Trusted reports whether addr belongs to a trusted proxy.
+func (r *Resolver) Trusted(addr netip.Addr) bool {
+	if r == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Resolve returns the client address of req. Starting from the peer, it walks the forwarding chain from
+right to left for as long as the hops are trusted proxies; the first untrusted hop is the client. When
+every hop is trusted the left-most one is used.
+*/
+func (r *Resolver) Resolve(req *http.Request) netip.Addr {
+	peer := ParseAddr(req.RemoteAddr)
+	if !r.Trusted(peer) {
+		return peer
+	}
+	chain := forwardedFor(req.Header)
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := ParseAddr(chain[i])
+		if !hop.IsValid() {
+			// Garbage in the chain, don't look any further than the last good hop
+			break
+		}
+		client = hop
+		if !r.Trusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the hops of X-Forwarded-For, or of the Forwarded "for" parameters when XFF is absent.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, v := range values {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return hops
+	}
+	for _, v := range h.Values("Forwarded") {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+/*
+ParseAddr parses the address forms found in RemoteAddr and forwarding headers: "1.2.3.4",
+"1.2.3.4:5678", "[2001:db8::1]:443" and "2001:db8::1". It returns the zero Addr for anything else,
+such as the obfuscated identifiers RFC 7239 allows.
+*/
+func ParseAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+type contextKey struct{}
+
+// NewContext stores the resolved client address, for FromContext further down the chain.
+func NewContext(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, contextKey{}, addr)
+}
+
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(contextKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// FromRequest returns the address stored in the request context, falling back to the peer address.
+func FromRequest(req *http.Request) netip.Addr {
+	if addr, ok := FromContext(req.Context()); ok {
+		return addr
+	}
+	return ParseAddr(req.RemoteAddr)
+}
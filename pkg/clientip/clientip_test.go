@@ -0,0 +1,53 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"})
+	assert.NoError(t, err, "Expected valid trusted proxies")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{name: "UntrustedPeerIgnoresHeaders", remoteAddr: "203.0.113.7:5000", headers: map[string]string{"X-Forwarded-For": "1.1.1.1"}, expected: "203.0.113.7"},
+		{name: "TrustedPeerWithoutHeaders", remoteAddr: "10.1.2.3:5000", expected: "10.1.2.3"},
+		{name: "TrustedPeerUsesLastUntrustedHop", remoteAddr: "10.1.2.3:5000", headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.4, 192.168.1.10"}, expected: "198.51.100.4"},
+		{name: "AllHopsTrusted", remoteAddr: "10.1.2.3:5000", headers: map[string]string{"X-Forwarded-For": "10.9.9.9, 10.8.8.8"}, expected: "10.9.9.9"},
+		{name: "GarbageStopsTheWalk", remoteAddr: "10.1.2.3:5000", headers: map[string]string{"X-Forwarded-For": "198.51.100.4, nonsense, 10.8.8.8"}, expected: "10.8.8.8"},
+		{name: "ForwardedHeaderFallback", remoteAddr: "[2001:db8::1]:443", headers: map[string]string{"Forwarded": `for="[2001:db9::5]:1234";proto=https, for=10.0.0.2`}, expected: "2001:db9::5"},
+		{name: "IPv4MappedPeer", remoteAddr: "[::ffff:10.0.0.1]:80", headers: map[string]string{"X-Forwarded-For": "198.51.100.4"}, expected: "198.51.100.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, netip.MustParseAddr(tt.expected), resolver.Resolve(req), "Unexpected client address")
+		})
+	}
+
+	t.Run("InvalidProxy", func(t *testing.T) {
+		_, err := NewResolver([]string{"10.0.0.0/33"})
+		assert.Error(t, err, "Expected an invalid CIDR to be rejected")
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		assert.Equal(t, netip.MustParseAddr("203.0.113.7"), FromRequest(req), "Expected the peer without a stored address")
+		req = req.WithContext(NewContext(req.Context(), netip.MustParseAddr("198.51.100.4")))
+		assert.Equal(t, netip.MustParseAddr("198.51.100.4"), FromRequest(req), "Expected the stored address")
+	})
+}
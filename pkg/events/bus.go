@@ -0,0 +1,81 @@
+/*
+Package events is a small in-process publish/subscribe bus. Publishing never blocks: each subscriber
+has its own buffer, and events that don't fit are dropped for that subscriber alone and counted, so a
+slow consumer can't hold up the code publishing.
+*/
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type Bus[T any] struct {
+	mux     sync.RWMutex
+	subs    map[*subscription[T]]struct{}
+	closed  bool
+	dropped atomic.Uint64
+}
+
+type subscription[T any] struct {
+	ch chan T
+}
+
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{subs: map[*subscription[T]]struct{}{}}
+}
+
+/*
+Subscribe returns a channel receiving every event published from now on, buffering up to buffer of
+them. The channel is closed by unsubscribe or Close; unsubscribe may be called more than once.
+*/
+func (b *Bus[T]) Subscribe(buffer int) (events <-chan T, unsubscribe func()) {
+	s := &subscription[T]{ch: make(chan T, buffer)}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	b.subs[s] = struct{}{}
+	return s.ch, func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		if _, ok := b.subs[s]; ok {
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Publish hands e to every subscriber with room for it.
+func (b *Bus[T]) Publish(e T) {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns how many deliveries were dropped because a subscriber's buffer was full.
+func (b *Bus[T]) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close closes every subscription; later events are discarded and later subscriptions come closed.
+func (b *Bus[T]) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		close(s.ch)
+	}
+	clear(b.subs)
+}
@@ -0,0 +1,53 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	t.Run("DeliversToEverySubscriber", func(t *testing.T) {
+		bus := NewBus[string]()
+		first, _ := bus.Subscribe(4)
+		second, _ := bus.Subscribe(4)
+		bus.Publish("up")
+		bus.Publish("down")
+		for _, ch := range []<-chan string{first, second} {
+			assert.Equal(t, "up", <-ch)
+			assert.Equal(t, "down", <-ch, "Expected the events in publishing order")
+		}
+	})
+
+	t.Run("SlowSubscriberDoesNotBlock", func(t *testing.T) {
+		bus := NewBus[int]()
+		slow, _ := bus.Subscribe(1)
+		fast, _ := bus.Subscribe(3)
+		for i := range 3 {
+			bus.Publish(i)
+		}
+		assert.Equal(t, uint64(2), bus.Dropped(), "Expected the events beyond the slow buffer to be dropped")
+		assert.Equal(t, 0, <-slow)
+		assert.Len(t, fast, 3, "Expected the other subscriber to get everything")
+	})
+
+	t.Run("UnsubscribeAndClose", func(t *testing.T) {
+		bus := NewBus[int]()
+		gone, unsubscribe := bus.Subscribe(1)
+		kept, _ := bus.Subscribe(1)
+		unsubscribe()
+		unsubscribe()
+		bus.Publish(1)
+		_, open := <-gone
+		assert.False(t, open, "Expected an unsubscribed channel to be closed")
+		assert.Equal(t, 1, <-kept)
+
+		bus.Close()
+		_, open = <-kept
+		assert.False(t, open, "Expected Close to end every subscription")
+		late, _ := bus.Subscribe(1)
+		bus.Publish(2)
+		_, open = <-late
+		assert.False(t, open, "Expected subscriptions after Close to come closed")
+	})
+}
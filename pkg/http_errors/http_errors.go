@@ -5,4 +5,7 @@ import "errors"
 var (
 	ErrNoHealthyBackend = errors.New("no healthy backends")
 	ErrInvalidConfig    = errors.New("invalid config")
+	ErrUpstreamFailed   = errors.New("all upstream attempts failed")
+	ErrNoRoute          = errors.New("no route matches the request")
+	ErrClientCertDenied = errors.New("client certificate not allowed on this route")
 )
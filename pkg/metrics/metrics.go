@@ -0,0 +1,196 @@
+/*
+Package metrics keeps counters and gauges in memory and serves them in the Prometheus text
+exposition format, without pulling in the Prometheus client library.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry is a set of metrics served together. It is an http.Handler for the /metrics endpoint.
+type Registry struct {
+	mux     sync.RWMutex
+	metrics map[string]*vec
+}
+
+// Default is the registry GoRelay's components register with and /metrics serves.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*vec{}}
+}
+
+type vec struct {
+	name, help, kind string
+	labels           []string
+	mux              sync.RWMutex
+	values           map[string]*value
+}
+
+type value struct {
+	labels []string
+	bits   atomic.Uint64 // float64 bits
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+/*
+register returns the metric called name, creating it on first use. Asking for an existing name again
+with another kind or other labels is a programming error and panics.
+*/
+func (r *Registry) register(name, help, kind string, labels []string) *vec {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if m.kind != kind || !slices.Equal(m.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different kinds or labels", name))
+		}
+		return m
+	}
+	m := &vec{name: name, help: help, kind: kind, labels: labels, values: map[string]*value{}}
+	r.metrics[name] = m
+	return m
+}
+
+func (m *vec) with(values []string) *value {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	m.mux.RLock()
+	v, ok := m.values[key]
+	m.mux.RUnlock()
+	if ok {
+		return v
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if v, ok = m.values[key]; !ok {
+		v = &value{labels: slices.Clone(values)}
+		m.values[key] = v
+	}
+	return v
+}
+
+// CounterVec is a family of counters that only go up, one per set of label values.
+type CounterVec struct{ vec *vec }
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels)}
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{c.vec.with(labelValues)}
+}
+
+type Counter struct{ v *value }
+
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add panics on negative deltas, counters never go down.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.v.add(delta)
+}
+
+func (c *Counter) Value() float64 { return c.v.load() }
+
+// GaugeVec is a family of values that go up and down, one per set of label values.
+type GaugeVec struct{ vec *vec }
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels)}
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{g.vec.with(labelValues)}
+}
+
+type Gauge struct{ v *value }
+
+func (g *Gauge) Set(v float64)     { g.v.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Add(delta float64) { g.v.add(delta) }
+func (g *Gauge) Inc()              { g.v.add(1) }
+func (g *Gauge) Dec()              { g.v.add(-1) }
+func (g *Gauge) Value() float64    { return g.v.load() }
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name and labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mux.RUnlock()
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		r.mux.RLock()
+		m := r.metrics[name]
+		r.mux.RUnlock()
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+
+		m.mux.RLock()
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			v := m.values[k]
+			b.WriteString(m.name)
+			if len(m.labels) > 0 {
+				b.WriteByte('{')
+				for i, l := range m.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, `%s="%s"`, l, escapeLabel(v.labels[i]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(v.load(), 'g', -1, 64))
+			b.WriteByte('\n')
+		}
+		m.mux.RUnlock()
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests served.", "pool", "code")
+	inflight := r.NewGaugeVec("test_inflight", "Requests in flight.")
+
+	requests.With("api", "200").Inc()
+	requests.With("api", "200").Add(2)
+	requests.With("web", "502").Inc()
+	requests.With("quote\"d", "200").Inc()
+	inflight.With().Inc()
+	inflight.With().Inc()
+	inflight.With().Dec()
+
+	assert.Equal(t, float64(3), requests.With("api", "200").Value(), "Expected the counter to add up")
+	assert.Same(t, requests.vec, r.NewCounterVec("test_requests_total", "Requests served.", "pool", "code").vec, "Expected the existing metric back")
+	assert.Panics(t, func() { r.NewGaugeVec("test_requests_total", "") }, "Expected a kind clash to panic")
+	assert.Panics(t, func() { requests.With("api") }, "Expected a label count mismatch to panic")
+	assert.Panics(t, func() { requests.With("api", "200").Add(-1) }, "Expected a decreasing counter to panic")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, `# HELP test_inflight Requests in flight.
+# TYPE test_inflight gauge
+test_inflight 1
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{pool="api",code="200"} 3
+test_requests_total{pool="quote\"d",code="200"} 1
+test_requests_total{pool="web",code="502"} 1
+`, w.Body.String(), "Unexpected exposition")
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain", "Expected the text format")
+}
@@ -0,0 +1,94 @@
+package proxyproto
+
+import (
+	"GoRelay/pkg/clientip"
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a trusted peer has to send its header.
+const DefaultHeaderTimeout time.Duration = 5 * time.Second
+
+// Listener expects a PROXY protocol header on connections from trusted peers, others are passed through as they are.
+type Listener struct {
+	net.Listener
+	trusted       *clientip.Resolver
+	headerTimeout time.Duration
+}
+
+func NewListener(ln net.Listener, trusted *clientip.Resolver) *Listener {
+	return &Listener{Listener: ln, trusted: trusted, headerTimeout: DefaultHeaderTimeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted.Trusted(clientip.ParseAddr(conn.RemoteAddr().String())) {
+		return conn, nil
+	}
+	// The header is read on first use, so a slow peer doesn't hold up the accept loop
+	return &Conn{Conn: conn, r: bufio.NewReader(conn), headerTimeout: l.headerTimeout}, nil
+}
+
+/*
+Conn is a connection from a trusted peer. Its header is read by the first Read, RemoteAddr, LocalAddr
+or Header call; RemoteAddr and LocalAddr then report the addresses it carries. A connection without a
+valid header is closed.
+*/
+type Conn struct {
+	net.Conn
+	r             *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Header returns the connection's header, reading it if that didn't happen yet.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		if c.headerTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		if c.header, c.err = Read(c.r); c.err != nil {
+			// Nothing is served to a peer that broke the protocol, not even an error response
+			c.Conn.Close()
+		}
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && !h.Local {
+		return net.TCPAddrFromAddrPort(h.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && !h.Local {
+		return net.TCPAddrFromAddrPort(h.Destination)
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the underlying connection, as a proxied *net.TCPConn would.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
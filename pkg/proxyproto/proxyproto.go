@@ -0,0 +1,214 @@
+/*
+Package proxyproto reads and writes PROXY protocol headers (versions 1 and 2), which L4 load balancers
+put in front of a connection to pass on the client's address. Only peers listed as trusted may send
+one; from them it is required, so an untrusted client can't claim any address it likes.
+*/
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	// v1 headers are at most 107 bytes, CRLF included
+	maxV1Length = 107
+	v1Prefix    = "PROXY "
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+/*
+Header is the connection as the sender saw it. Local is set for connections the sender opened itself
+(v2 LOCAL, v1 UNKNOWN, or addresses other than IPv4 and IPv6); Source and Destination are unset then.
+*/
+type Header struct {
+	Version     int
+	Local       bool
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+// NewHeader describes a connection from src to dst, Local when they aren't IP addresses.
+func NewHeader(version int, src, dst net.Addr) *Header {
+	h := &Header{Version: version, Source: addrPort(src), Destination: addrPort(dst)}
+	h.Local = !h.Source.IsValid() || !h.Destination.IsValid()
+	return h
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.AddrPort()
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap
+}
+
+// Read reads a header of either version from the start of r.
+func Read(r *bufio.Reader) (*Header, error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	switch {
+	case string(start) == v1Prefix:
+		return readV1(r)
+	case bytes.HasPrefix(v2Signature, start):
+		return readV2(r)
+	}
+	return nil, fmt.Errorf("%w: missing", ErrInvalidHeader)
+}
+
+// readV1 parses "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1Length {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrInvalidHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+	src, err1 := parseV1Addr(fields[2], fields[4])
+	dst, err2 := parseV1Addr(fields[3], fields[5])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if src.Addr().Is4() != (fields[1] == "TCP4") || dst.Addr().Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("%w: addresses don't match %s", ErrInvalidHeader, fields[1])
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(addr, port string) (netip.AddrPort, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(ip, uint16(p)), nil
+}
+
+// readV2 parses the binary header: signature, version and command, family and transport, length, addresses and TLVs.
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if !bytes.Equal(fixed[:12], v2Signature) || fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: bad v2 signature or version", ErrInvalidHeader)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	h := &Header{Version: 2}
+	switch fixed[12] & 0x0f {
+	case 0x0: // LOCAL
+		h.Local = true
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unknown v2 command %#x", ErrInvalidHeader, fixed[12]&0x0f)
+	}
+	// TLVs after the addresses are skipped, nothing here needs them
+	switch family := fixed[13] >> 4; {
+	case family == 0x1 && len(payload) >= 12:
+		h.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:10]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:12]))
+	case family == 0x2 && len(payload) >= 36:
+		h.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:34]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:36]))
+	case family == 0x1 || family == 0x2:
+		return nil, fmt.Errorf("%w: v2 address block too short", ErrInvalidHeader)
+	default:
+		// Unix sockets and unspecified families carry nothing usable as a client address
+		h.Local = true
+	}
+	return h, nil
+}
+
+// Format encodes h as its Version, the addresses as IPv6 unless both are IPv4.
+func (h *Header) Format() ([]byte, error) {
+	src, dst := h.Source, h.Destination
+	v4 := src.Addr().Unmap().Is4() && dst.Addr().Unmap().Is4()
+	if v4 {
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	} else {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+	switch h.Version {
+	case 1:
+		if h.Local {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", proto, src.Addr(), dst.Addr(), src.Port(), dst.Port()), nil
+	case 2:
+		buf := append([]byte{}, v2Signature...)
+		if h.Local {
+			return append(buf, 0x20, 0x00, 0, 0), nil
+		}
+		var addrs []byte
+		family := byte(0x21) // INET6, STREAM
+		if v4 {
+			family = 0x11 // INET, STREAM
+			s, d := src.Addr().As4(), dst.Addr().As4()
+			addrs = append(s[:], d[:]...)
+		} else {
+			s, d := src.Addr().As16(), dst.Addr().As16()
+			addrs = append(s[:], d[:]...)
+		}
+		addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+		addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+		buf = append(buf, 0x21, family)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(addrs)))
+		return append(buf, addrs...), nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+}
+
+// WriteTo writes the formatted header to w.
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	buf, err := h.Format()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
@@ -0,0 +1,151 @@
+package proxyproto
+
+import (
+	"GoRelay/pkg/clientip"
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRead(t *testing.T) {
+	v2 := func(s string) string {
+		b, _ := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+		return string(b)
+	}
+	tests := []struct {
+		name     string
+		input    string
+		expected *Header
+	}{
+		{name: "V1TCP4", input: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+			expected: &Header{Version: 1, Source: netip.MustParseAddrPort("192.0.2.1:56324"), Destination: netip.MustParseAddrPort("198.51.100.1:443")}},
+		{name: "V1TCP6", input: "PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n",
+			expected: &Header{Version: 1, Source: netip.MustParseAddrPort("[2001:db8::1]:1000"), Destination: netip.MustParseAddrPort("[2001:db8::2]:80")}},
+		{name: "V1Unknown", input: "PROXY UNKNOWN ignored\r\n", expected: &Header{Version: 1, Local: true}},
+		{name: "V2TCP4WithTLV", input: v2("0d0a0d0a000d0a515549540a 21 11 0010 c0000201 c6336401 dc04 01bb 0400 01 00"),
+			expected: &Header{Version: 2, Source: netip.MustParseAddrPort("192.0.2.1:56324"), Destination: netip.MustParseAddrPort("198.51.100.1:443")}},
+		{name: "V2Local", input: v2("0d0a0d0a000d0a515549540a 20 00 0000"), expected: &Header{Version: 2, Local: true}},
+		{name: "V2Unix", input: v2("0d0a0d0a000d0a515549540a 21 31 0004 00000000"), expected: &Header{Version: 2, Local: true}},
+		{name: "Missing", input: "GET / HTTP/1.1\r\n\r\n"},
+		{name: "V1Truncated", input: "PROXY TCP4 192.0.2.1"},
+		{name: "V1FamilyMismatch", input: "PROXY TCP4 2001:db8::1 2001:db8::2 1000 80\r\n"},
+		{name: "V1TooLong", input: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"},
+		{name: "V2ShortAddresses", input: v2("0d0a0d0a000d0a515549540a 21 11 0004 c0000201")},
+		{name: "V2BadVersion", input: v2("0d0a0d0a000d0a515549540a 11 11 0000")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input + "payload"))
+			h, err := Read(r)
+			if tt.expected == nil {
+				assert.ErrorIs(t, err, ErrInvalidHeader, "Expected the header to be rejected")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, h)
+			rest, _ := io.ReadAll(r)
+			assert.Equal(t, "payload", string(rest), "Expected the data after the header to be left alone")
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		for _, h := range []*Header{
+			{Version: version, Source: netip.MustParseAddrPort("192.0.2.1:56324"), Destination: netip.MustParseAddrPort("198.51.100.1:443")},
+			{Version: version, Source: netip.MustParseAddrPort("[2001:db8::1]:1000"), Destination: netip.MustParseAddrPort("[2001:db8::2]:80")},
+			{Version: version, Local: true},
+		} {
+			buf, err := h.Format()
+			assert.NoError(t, err)
+			parsed, err := Read(bufio.NewReader(strings.NewReader(string(buf))))
+			assert.NoError(t, err, "Expected %q to parse", buf)
+			assert.Equal(t, h, parsed, "Expected the header to survive a round trip")
+		}
+	}
+
+	buf, _ := (&Header{Version: 1, Source: netip.MustParseAddrPort("192.0.2.1:56324"), Destination: netip.MustParseAddrPort("198.51.100.1:443")}).Format()
+	assert.Equal(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", string(buf))
+
+	// Mixed families are sent as IPv6
+	mixed := &Header{Version: 2, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("[2001:db8::2]:2")}
+	buf, _ = mixed.Format()
+	parsed, err := Read(bufio.NewReader(strings.NewReader(string(buf))))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("::ffff:192.0.2.1"), parsed.Source.Addr())
+
+	_, err = (&Header{Version: 3}).Format()
+	assert.Error(t, err, "Expected an unknown version to be rejected")
+}
+
+func TestListener(t *testing.T) {
+	// listen serves HTTP behind a PROXY protocol listener, answering with the request's RemoteAddr.
+	listen := func(t *testing.T, trusted ...string) string {
+		t.Helper()
+		resolver, err := clientip.NewResolver(trusted)
+		assert.NoError(t, err)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		l := NewListener(ln, resolver)
+		l.headerTimeout = 200 * time.Millisecond
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		})}
+		go srv.Serve(l)
+		t.Cleanup(func() { srv.Close() })
+		return ln.Addr().String()
+	}
+	get := func(t *testing.T, addr, header string) (int, string, error) {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer conn.Close()
+		io.WriteString(conn, header+"GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), nil
+	}
+
+	t.Run("TrustedPeerReportsClient", func(t *testing.T) {
+		addr := listen(t, "127.0.0.0/8")
+		_, remote, err := get(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 40000 80\r\n")
+		assert.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:40000", remote, "Expected the client from the header")
+	})
+
+	t.Run("TrustedPeerLocalHeader", func(t *testing.T) {
+		addr := listen(t, "127.0.0.1")
+		_, remote, err := get(t, addr, "PROXY UNKNOWN\r\n")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(remote, "127.0.0.1:"), "Expected the peer itself for a local connection")
+	})
+
+	t.Run("TrustedPeerWithoutHeader", func(t *testing.T) {
+		addr := listen(t, "127.0.0.1")
+		_, _, err := get(t, addr, "")
+		assert.Error(t, err, "Expected a trusted peer without a header to be refused")
+	})
+
+	t.Run("UntrustedPeerIsTakenAsIs", func(t *testing.T) {
+		addr := listen(t, "10.0.0.0/8")
+		_, remote, err := get(t, addr, "")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(remote, "127.0.0.1:"), "Expected the real peer")
+
+		status, _, _ := get(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 40000 80\r\n")
+		assert.Equal(t, http.StatusBadRequest, status, "Expected a header from an untrusted peer to be a bad request")
+	})
+}
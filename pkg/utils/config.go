@@ -1,10 +1,308 @@
 package utils
 
-import "time"
+import (
+	"time"
 
+	"gopkg.in/yaml.v3"
+)
+
+// The top-level backends, algorithm and hash settings describe the "default" pool.
 type Config struct {
-	Port           string        `yaml:"port" validate:"required,numeric"`
-	Backends       []string      `yaml:"backends" validate:"required,dive,required"`
-	HealthInterval time.Duration `yaml:"healthInterval" validate:"gt=0"`
-	Algorithm      string        `yaml:"algorithm" validate:"oneof=roundRobin round_robin leastconn least_conn"`
+	Port           string                `yaml:"port" validate:"omitempty,numeric"`
+	Backends       []BackendConfig       `yaml:"backends" validate:"required_without=Pools,dive"`
+	HealthInterval time.Duration         `yaml:"healthInterval" validate:"gt=0"`
+	Algorithm      string                `yaml:"algorithm" validate:"algorithm"`
+	FlushInterval  time.Duration         `yaml:"flushInterval"`
+	Retry          RetryConfig           `yaml:"retry"`
+	Pools          map[string]PoolConfig `yaml:"pools" validate:"dive"`
+	Routes         []RouteConfig         `yaml:"routes" validate:"dive"`
+	Hash           HashConfig            `yaml:"hash"`
+	Affinity       AffinityConfig        `yaml:"affinity"`
+	EWMADecay      time.Duration         `yaml:"ewmaDecay" validate:"gte=0"`
+
+	OutlierDetection OutlierDetectionConfig `yaml:"outlierDetection"`
+	// Proxies (CIDRs or addresses) whose X-Forwarded-For/Forwarded headers are believed and extended
+	TrustedProxies []string `yaml:"trustedProxies" validate:"dive,cidr|ip"`
+	// Header rules of the default pool
+	HeaderRules HeaderRulesConfig `yaml:"headerRules"`
+	// Extra listeners next to the plain HTTP one on Port, at least one of the two is required
+	Listeners []ListenerConfig `yaml:"listeners" validate:"dive"`
+	// TLS and protocol towards the backends of the default pool
+	UpstreamTLS      UpstreamTLSConfig `yaml:"upstreamTLS"`
+	UpstreamProtocol string            `yaml:"upstreamProtocol" validate:"omitempty,oneof=http1 h2 h2c"`
+	// Accept cleartext HTTP/2 (h2c) on the port listener
+	H2C bool `yaml:"h2c"`
+	// Peers (CIDRs or addresses) that must open connections to the port listener with a PROXY protocol header
+	ProxyProtocol []string `yaml:"proxyProtocol" validate:"dive,cidr|ip"`
+	// Limits of WebSocket and other upgraded connections, and how long shutdown drains them
+	Upgrade UpgradeConfig `yaml:"upgrade"`
+	// Active health check of the default pool
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+	// How the health checks of all pools are spread and bounded
+	HealthScheduler HealthSchedulerConfig `yaml:"healthScheduler"`
+	// Address serving the metrics on /metrics, none when empty
+	MetricsAddress string `yaml:"metricsAddress"`
+}
+
+/*
+ListenerConfig is an address to accept connections on, serving HTTPS when TLS is set. A tcp or udp
+listener balances raw connections or datagrams over Pool (the default pool when empty), whose backends
+are tcp://host:port or udp://host:port. For udp, IdleTimeout is how long a client's session lasts without traffic
+and MaxSessions bounds how many clients have one at a time (10000 when 0).
+*/
+type ListenerConfig struct {
+	Name    string     `yaml:"name"`
+	Address string     `yaml:"address" validate:"required"`
+	TLS     *TLSConfig `yaml:"tls"`
+	// Accept cleartext HTTP/2 (h2c), for listeners without TLS
+	H2C      bool   `yaml:"h2c"`
+	Protocol string `yaml:"protocol" validate:"omitempty,oneof=http tcp udp"`
+
+	Pool            string        `yaml:"pool"`
+	ConnectTimeout  time.Duration `yaml:"connectTimeout" validate:"gte=0"`
+	ConnectAttempts int           `yaml:"connectAttempts" validate:"gte=0"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout" validate:"gte=0"`
+	MaxSessions     int           `yaml:"maxSessions" validate:"gte=0"`
+
+	// Peers (CIDRs or addresses) that must open connections with a PROXY protocol header, not for udp
+	ProxyProtocol []string `yaml:"proxyProtocol" validate:"dive,cidr|ip"`
+	// PROXY protocol version sent to the backends of a tcp listener, none when empty
+	SendProxyProtocol string `yaml:"sendProxyProtocol" validate:"omitempty,oneof=v1 v2"`
+}
+
+/*
+TLSConfig terminates TLS on a listener. The certificate is chosen by SNI: the first one valid for the
+requested name wins, the first one overall when none is. Changed files are picked up every
+ReloadInterval (10s when 0).
+*/
+type TLSConfig struct {
+	Certificates   []CertificateConfig `yaml:"certificates" validate:"required,min=1,dive"`
+	MinVersion     string              `yaml:"minVersion" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+	CipherSuites   []string            `yaml:"cipherSuites"`
+	DisableHTTP2   bool                `yaml:"disableHTTP2"`
+	ReloadInterval time.Duration       `yaml:"reloadInterval" validate:"gte=0"`
+	ClientAuth     ClientAuthConfig    `yaml:"clientAuth"`
+}
+
+/*
+ClientAuthConfig asks clients for a certificate signed by CAFile. With "optional" clients without one
+are let in (routes can still demand one), "require" refuses the handshake. A certificate that is
+presented but does not verify is always refused.
+*/
+type ClientAuthConfig struct {
+	Mode   string `yaml:"mode" validate:"omitempty,oneof=none optional require"`
+	CAFile string `yaml:"caFile"`
+}
+
+type CertificateConfig struct {
+	CertFile string `yaml:"certFile" validate:"required"`
+	KeyFile  string `yaml:"keyFile" validate:"required"`
+}
+
+// HeaderRulesConfig edits the headers of requests sent upstream and of the responses coming back.
+type HeaderRulesConfig struct {
+	Request  HeaderOpsConfig `yaml:"request"`
+	Response HeaderOpsConfig `yaml:"response"`
+}
+
+/*
+HeaderOpsConfig is applied in the order rename, remove, set, add. Set and add values may use
+${client_ip}, ${request_id}, ${route} and ${backend}; $$ is a literal $.
+*/
+type HeaderOpsConfig struct {
+	Rename map[string]string `yaml:"rename"`
+	Remove []string          `yaml:"remove"`
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+}
+
+// Weight 0 is treated as 1, so plain URL entries all share the traffic evenly.
+type BackendConfig struct {
+	URL    string `yaml:"url" validate:"required"`
+	Weight int    `yaml:"weight" validate:"gte=0"`
+}
+
+// UnmarshalYAML accepts both the plain "- http://host:port" form and the {url, weight} mapping.
+func (b *BackendConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.URL = node.Value
+		return nil
+	}
+	type plain BackendConfig
+	return node.Decode((*plain)(b))
+}
+
+// Name is the header or cookie to hash on when Key is header or cookie.
+type HashConfig struct {
+	Key          string `yaml:"key" validate:"omitempty,oneof=client_ip header cookie path"`
+	Name         string `yaml:"name"`
+	VirtualNodes int    `yaml:"virtualNodes" validate:"gte=0"`
+}
+
+type AffinityConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	CookieName string        `yaml:"cookieName"`
+	Secret     string        `yaml:"secret"`
+	TTL        time.Duration `yaml:"ttl" validate:"gte=0"`
+	Path       string        `yaml:"path"`
+	Domain     string        `yaml:"domain"`
+	Secure     bool          `yaml:"secure"`
+	HTTPOnly   *bool         `yaml:"httpOnly"`
+	SameSite   string        `yaml:"sameSite" validate:"omitempty,oneof=lax strict none"`
+}
+
+type RetryConfig struct {
+	MaxAttempts        int           `yaml:"maxAttempts" validate:"gte=0"`
+	RetryOnStatus      []int         `yaml:"retryOnStatus" validate:"dive,min=100,max=599"`
+	RetryOn            []string      `yaml:"retryOn" validate:"dive,oneof=connect-failure reset timeout"`
+	PerAttemptTimeout  time.Duration `yaml:"perAttemptTimeout" validate:"gte=0"`
+	BackoffBase        time.Duration `yaml:"backoffBase" validate:"gte=0"`
+	BackoffMax         time.Duration `yaml:"backoffMax" validate:"gte=0"`
+	DifferentBackend   *bool         `yaml:"differentBackend"`
+	MaxBodyBytes       int64         `yaml:"maxBodyBytes" validate:"gte=0"`
+	AllowNonIdempotent *bool         `yaml:"allowNonIdempotent"`
+	// gRPC statuses returned as a trailers-only response; these and connect failures are retried regardless of AllowNonIdempotent
+	RetryOnGRPC []string `yaml:"retryOnGrpc" validate:"dive,oneof=cancelled unknown deadline-exceeded resource-exhausted internal unavailable"`
+}
+
+// PoolConfig is a named group of backends; unset algorithm and healthInterval fall back to the top-level ones.
+type PoolConfig struct {
+	Backends       []BackendConfig   `yaml:"backends" validate:"required,dive"`
+	Algorithm      string            `yaml:"algorithm" validate:"omitempty,algorithm"`
+	HealthInterval time.Duration     `yaml:"healthInterval" validate:"gte=0"`
+	Hash           HashConfig        `yaml:"hash"`
+	HeaderRules    HeaderRulesConfig `yaml:"headerRules"`
+	TLS            UpstreamTLSConfig `yaml:"tls"`
+	// http1, h2 (over TLS) or h2c (cleartext HTTP/2, e.g. gRPC backends); HTTP/2 is negotiated over TLS when empty
+	Protocol    string            `yaml:"protocol" validate:"omitempty,oneof=http1 h2 h2c"`
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+}
+
+/*
+UpstreamTLSConfig is how a pool talks TLS to its https:// backends, and what its health checks use.
+CAFile replaces the system roots, CertFile/KeyFile present a client certificate (reloaded when the
+files change) and ServerName overrides the name verified and sent as SNI.
+*/
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile" validate:"required_with=KeyFile"`
+	KeyFile            string `yaml:"keyFile" validate:"required_with=CertFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	MinVersion         string `yaml:"minVersion" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+}
+
+/*
+RouteConfig sends matching requests to Pool (the default pool when empty). Every condition that is set
+must match; routes are tried in order and the first match wins. A header condition with an empty value
+only requires the header to be present.
+*/
+type RouteConfig struct {
+	Name       string            `yaml:"name"`
+	Host       string            `yaml:"host"`
+	PathPrefix string            `yaml:"pathPrefix" validate:"omitempty,startswith=/"`
+	PathRegex  string            `yaml:"pathRegex"`
+	Methods    []string          `yaml:"methods"`
+	Headers    map[string]string `yaml:"headers"`
+	Pool       string            `yaml:"pool"`
+	// Fields set here override the pool's retry policy
+	Retry   *RetryConfig  `yaml:"retry"`
+	Rewrite RewriteConfig `yaml:"rewrite"`
+	// Applied after the pool's rules
+	HeaderRules HeaderRulesConfig `yaml:"headerRules"`
+	ClientCert  *ClientCertConfig `yaml:"clientCert"`
+}
+
+/*
+ClientCertConfig only lets requests through that came with a verified client certificate whose
+subject (e.g. "CN=billing,O=Acme") or one of whose SANs (DNS, URI, email, IP) matches a pattern.
+Patterns use path.Match syntax, so "*" does not cross a "/" in URI SANs.
+*/
+type ClientCertConfig struct {
+	Subjects []string `yaml:"subjects"`
+	SANs     []string `yaml:"sans"`
+}
+
+/*
+RewriteConfig changes the path sent upstream. StripPrefix and ReplacePrefix act on the route's
+PathPrefix and exclude each other; Regex/Replacement (with $1-style groups) is applied afterwards.
+*/
+type RewriteConfig struct {
+	StripPrefix   bool   `yaml:"stripPrefix"`
+	ReplacePrefix string `yaml:"replacePrefix" validate:"omitempty,startswith=/"`
+	Regex         string `yaml:"regex"`
+	Replacement   string `yaml:"replacement"`
+}
+
+// Zero timeouts leave upgraded connections open for as long as the peers keep them.
+type UpgradeConfig struct {
+	IdleTimeout  time.Duration `yaml:"idleTimeout" validate:"gte=0"`
+	MaxLifetime  time.Duration `yaml:"maxLifetime" validate:"gte=0"`
+	DrainTimeout time.Duration `yaml:"drainTimeout" validate:"gte=0"`
+}
+
+/*
+HealthCheckConfig is how a pool probes its backends. Type is http, tcp (connect only), send_expect or
+grpc; when empty tcp:// backends are connected to, udp:// ones aren't checked and the rest get http.
+
+For http, Path is appended to the backend URL's path as proxied requests are (the URL itself when
+empty). ExpectedStatus takes codes ("204"), ranges ("200-399") and classes ("2xx"), 2xx when empty.
+With BodyRegex or JSONPath the body must match too; JSONPath is a dotted path ("checks.db.status",
+array indexes as numbers) that must exist, and equal JSONValue when that is set. Method defaults to
+HEAD, or GET when the body is checked.
+
+send_expect writes Send and expects the reply to start with Expect. grpc calls
+grpc.health.v1.Health/Check for Service (the whole server when empty) and needs HTTP/2 to the backends:
+https:// ones negotiate it, http:// ones need the pool's protocol set to h2c.
+
+A backend turns alive after Rise consecutive passing checks and dead after Fall failing ones, both 1
+when unset. While it is dead it is checked every UnhealthyInterval, the pool's interval when unset.
+Flap takes a backend out of rotation while it keeps going up and down.
+*/
+type HealthCheckConfig struct {
+	Type           string            `yaml:"type" validate:"omitempty,oneof=http tcp send_expect grpc"`
+	Path           string            `yaml:"path" validate:"omitempty,startswith=/"`
+	Method         string            `yaml:"method" validate:"omitempty,oneof=GET HEAD POST PUT OPTIONS"`
+	Host           string            `yaml:"host"`
+	Headers        map[string]string `yaml:"headers"`
+	ExpectedStatus []string          `yaml:"expectedStatus"`
+	BodyRegex      string            `yaml:"bodyRegex"`
+	JSONPath       string            `yaml:"jsonPath"`
+	JSONValue      string            `yaml:"jsonValue"`
+	Send           string            `yaml:"send"`
+	Expect         string            `yaml:"expect"`
+	Service        string            `yaml:"service"`
+	Timeout        time.Duration     `yaml:"timeout" validate:"gte=0"`
+	Rise           int               `yaml:"rise" validate:"gte=0"`
+	Fall           int               `yaml:"fall" validate:"gte=0"`
+
+	UnhealthyInterval time.Duration       `yaml:"unhealthyInterval" validate:"gte=0"`
+	Flap              FlapDetectionConfig `yaml:"flap"`
+}
+
+// A backend changing state Transitions times within Window is held out of rotation for Hold (Window when unset). Off when Transitions is 0, Window is required otherwise.
+type FlapDetectionConfig struct {
+	Window      time.Duration `yaml:"window" validate:"gte=0"`
+	Transitions int           `yaml:"transitions" validate:"gte=0"`
+	Hold        time.Duration `yaml:"hold" validate:"gte=0"`
+}
+
+// Zero values keep the defaults: 32 probes at once and 10% jitter.
+type HealthSchedulerConfig struct {
+	MaxConcurrent int     `yaml:"maxConcurrent" validate:"gte=0"`
+	Jitter        float64 `yaml:"jitter" validate:"gte=0,lte=1"`
+}
+
+// Pointer fields distinguish "not set" (use the default) from an explicit 0 that disables a check.
+type OutlierDetectionConfig struct {
+	Disabled                  bool          `yaml:"disabled"`
+	Consecutive5xx            *int          `yaml:"consecutive5xx" validate:"omitempty,gte=0"`
+	ConsecutiveGatewayFailure *int          `yaml:"consecutiveGatewayFailure" validate:"omitempty,gte=0"`
+	Interval                  time.Duration `yaml:"interval" validate:"gte=0"`
+	BaseEjectionTime          time.Duration `yaml:"baseEjectionTime" validate:"gte=0"`
+	MaxEjectionTime           time.Duration `yaml:"maxEjectionTime" validate:"gte=0"`
+	MaxEjectionPercent        *int          `yaml:"maxEjectionPercent" validate:"omitempty,gte=0,lte=100"`
+	SuccessRateMinimumHosts   int           `yaml:"successRateMinimumHosts" validate:"gte=0"`
+	SuccessRateRequestVolume  int           `yaml:"successRateRequestVolume" validate:"gte=0"`
+	SuccessRateStdevFactor    float64       `yaml:"successRateStdevFactor" validate:"gte=0"`
 }
@@ -1,9 +1,238 @@
 package utils
 
-import "github.com/go-playground/validator"
+import (
+	"GoRelay/pkg/certs"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
-func ValidateConfig(cfg *Config) error {
+	"github.com/go-playground/validator"
+)
+
+// NormalizeAlgorithm maps the spellings of a balancing algorithm name (round_robin, roundRobin, round-robin) onto one key.
+func NormalizeAlgorithm(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// ValidateConfig checks cfg, accepting the balancing algorithms listed in algorithms (see usecase.BalancerNames).
+func ValidateConfig(cfg *Config, algorithms []string) error {
+	accepted := make(map[string]bool, len(algorithms))
+	for _, name := range algorithms {
+		accepted[NormalizeAlgorithm(name)] = true
+	}
 	validate := validator.New()
+	validate.RegisterValidation("algorithm", func(fl validator.FieldLevel) bool {
+		return accepted[NormalizeAlgorithm(fl.Field().String())]
+	})
+	validate.RegisterStructValidation(validateHashConfig, HashConfig{})
+	validate.RegisterStructValidation(validateTLSConfig, TLSConfig{})
+	validate.RegisterStructValidation(validateHealthCheckConfig, HealthCheckConfig{})
+	// Only one struct level validation can be registered per type
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		validateRoutes(sl)
+		validateListeners(sl)
+		validateHealthChecks(sl)
+	}, Config{})
 	err := validate.Struct(cfg)
 	return err
 }
+
+func validateHashConfig(sl validator.StructLevel) {
+	hash := sl.Current().Interface().(HashConfig)
+	if (hash.Key == "header" || hash.Key == "cookie") && hash.Name == "" {
+		sl.ReportError(hash.Name, "Name", "name", "required_with_key", hash.Key)
+	}
+}
+
+// DefaultPool is the name of the pool built from the top-level backends.
+const DefaultPool = "default"
+
+func validateRoutes(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	if _, clash := cfg.Pools[DefaultPool]; clash && len(cfg.Backends) > 0 {
+		sl.ReportError(cfg.Pools, "Pools", "pools", "default_pool_clash", DefaultPool)
+	}
+	for i, route := range cfg.Routes {
+		pool := route.Pool
+		if pool == "" {
+			pool = DefaultPool
+		}
+		backends, ok := poolBackends(cfg, pool)
+		if !ok {
+			sl.ReportError(route.Pool, "Routes["+strconv.Itoa(i)+"].Pool", "pool", "known_pool", pool)
+		} else if HasRawBackends(backends) {
+			sl.ReportError(route.Pool, "Routes["+strconv.Itoa(i)+"].Pool", "pool", "http_backends", pool)
+		}
+		if route.PathRegex != "" {
+			if _, err := regexp.Compile(route.PathRegex); err != nil {
+				sl.ReportError(route.PathRegex, "Routes["+strconv.Itoa(i)+"].PathRegex", "pathRegex", "regexp", "")
+			}
+		}
+		if route.ClientCert != nil {
+			for _, pattern := range append(slices.Clone(route.ClientCert.Subjects), route.ClientCert.SANs...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					sl.ReportError(pattern, "Routes["+strconv.Itoa(i)+"].ClientCert", "clientCert", "pattern", "")
+				}
+			}
+		}
+		rewrite := route.Rewrite
+		if (rewrite.StripPrefix || rewrite.ReplacePrefix != "") && route.PathPrefix == "" {
+			sl.ReportError(rewrite, "Routes["+strconv.Itoa(i)+"].Rewrite", "rewrite", "required_path_prefix", "")
+		}
+		if rewrite.StripPrefix && rewrite.ReplacePrefix != "" {
+			sl.ReportError(rewrite.ReplacePrefix, "Routes["+strconv.Itoa(i)+"].Rewrite.ReplacePrefix", "replacePrefix", "excluded_with", "StripPrefix")
+		}
+		if rewrite.Regex != "" {
+			if _, err := regexp.Compile(rewrite.Regex); err != nil {
+				sl.ReportError(rewrite.Regex, "Routes["+strconv.Itoa(i)+"].Rewrite.Regex", "regex", "regexp", "")
+			}
+		}
+	}
+}
+
+// poolBackends returns the backends of the named pool, the top-level ones for the default pool, and whether it exists.
+func poolBackends(cfg Config, pool string) ([]BackendConfig, bool) {
+	if pc, named := cfg.Pools[pool]; named {
+		return pc.Backends, true
+	}
+	if pool == DefaultPool && len(cfg.Backends) > 0 {
+		return cfg.Backends, true
+	}
+	return nil, false
+}
+
+// HasRawBackends reports whether a pool has tcp:// or udp:// backends, which only tcp and udp listeners can use.
+func HasRawBackends(backends []BackendConfig) bool {
+	for _, b := range backends {
+		if u, err := url.Parse(b.URL); err == nil && (u.Scheme == "tcp" || u.Scheme == "udp") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateListeners(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	if cfg.Port == "" && len(cfg.Listeners) == 0 {
+		sl.ReportError(cfg.Port, "Port", "port", "required_without", "Listeners")
+	}
+	// HTTP listeners send what no route matches to the default pool, which must speak HTTP then
+	defaultBackends, _ := poolBackends(cfg, DefaultPool)
+	rawDefault := HasRawBackends(defaultBackends)
+	if cfg.Port != "" && rawDefault {
+		sl.ReportError(cfg.Port, "Port", "port", "http_backends", DefaultPool)
+	}
+	for i, l := range cfg.Listeners {
+		field := "Listeners[" + strconv.Itoa(i) + "]"
+		if l.Protocol != "tcp" && l.Protocol != "udp" && rawDefault {
+			sl.ReportError(l.Address, field+".Address", "address", "http_backends", DefaultPool)
+		}
+		if l.SendProxyProtocol != "" && l.Protocol != "tcp" {
+			sl.ReportError(l.SendProxyProtocol, field+".SendProxyProtocol", "sendProxyProtocol", "excluded_unless", "Protocol tcp")
+		}
+		if l.MaxSessions > 0 && l.Protocol != "udp" {
+			sl.ReportError(l.MaxSessions, field+".MaxSessions", "maxSessions", "excluded_unless", "Protocol udp")
+		}
+		if len(l.ProxyProtocol) > 0 && l.Protocol == "udp" {
+			sl.ReportError(l.ProxyProtocol, field+".ProxyProtocol", "proxyProtocol", "excluded_if", "Protocol udp")
+		}
+		if l.Protocol != "tcp" && l.Protocol != "udp" {
+			continue
+		}
+		if l.TLS != nil || l.H2C {
+			sl.ReportError(l.TLS, field+".TLS", "tls", "excluded_with", "Protocol")
+		}
+		pool := l.Pool
+		if pool == "" {
+			pool = DefaultPool
+		}
+		backends, _ := poolBackends(cfg, pool)
+		if len(backends) == 0 {
+			sl.ReportError(l.Pool, field+".Pool", "pool", "known_pool", pool)
+		}
+		// Raw traffic can only go to plain addresses of the same protocol, not to HTTP URLs
+		for _, b := range backends {
+			if u, err := url.Parse(b.URL); err != nil || u.Scheme != l.Protocol || u.Port() == "" {
+				sl.ReportError(b.URL, field+".Pool", "pool", l.Protocol+"_backends", pool)
+			}
+		}
+	}
+}
+
+func validateTLSConfig(sl validator.StructLevel) {
+	tlsCfg := sl.Current().Interface().(TLSConfig)
+	if _, err := certs.ParseCipherSuites(tlsCfg.CipherSuites); err != nil {
+		sl.ReportError(tlsCfg.CipherSuites, "CipherSuites", "cipherSuites", "cipher_suite", "")
+	}
+	if mode := tlsCfg.ClientAuth.Mode; mode != "" && mode != "none" && tlsCfg.ClientAuth.CAFile == "" {
+		sl.ReportError(tlsCfg.ClientAuth.CAFile, "ClientAuth.CAFile", "caFile", "required_with_mode", mode)
+	}
+}
+
+// validateHealthChecks checks that each pool's health check type can probe its backends.
+func validateHealthChecks(sl validator.StructLevel) {
+	cfg := sl.Current().Interface().(Config)
+	pools := map[string]PoolConfig{}
+	for name, pc := range cfg.Pools {
+		pools[name] = pc
+	}
+	if len(cfg.Backends) > 0 {
+		pools[DefaultPool] = PoolConfig{Backends: cfg.Backends, Protocol: cfg.UpstreamProtocol, HealthCheck: cfg.HealthCheck}
+	}
+	for name, pc := range pools {
+		check := pc.HealthCheck.Type
+		if check == "grpc" && pc.Protocol == "http1" {
+			sl.ReportError(check, "Pools["+name+"].HealthCheck.Type", "type", "grpc_needs_http2", pc.Protocol)
+		}
+		for _, b := range pc.Backends {
+			u, err := url.Parse(b.URL)
+			if err != nil {
+				continue
+			}
+			// Raw pools can't answer HTTP probes, and UDP backends have nothing to connect to
+			raw := u.Scheme == "tcp" || u.Scheme == "udp"
+			if (raw && (check == "http" || check == "grpc")) || (u.Scheme == "udp" && check != "") {
+				sl.ReportError(check, "Pools["+name+"].HealthCheck.Type", "type", "check_for_"+u.Scheme, name)
+				break
+			}
+			// Without TLS there is no ALPN to agree on HTTP/2, cleartext backends get HTTP/1.1 unless told otherwise
+			if check == "grpc" && u.Scheme == "http" && pc.Protocol != "h2c" {
+				sl.ReportError(check, "Pools["+name+"].HealthCheck.Type", "type", "grpc_needs_h2c", name)
+				break
+			}
+		}
+	}
+}
+
+// statusPattern matches the forms of HealthCheckConfig.ExpectedStatus: "200", "200-399" and "2xx".
+var statusPattern = regexp.MustCompile(`^([1-5]xx|[1-5][0-9]{2}(-[1-5][0-9]{2})?)$`)
+
+func validateHealthCheckConfig(sl validator.StructLevel) {
+	check := sl.Current().Interface().(HealthCheckConfig)
+	for _, status := range check.ExpectedStatus {
+		if !statusPattern.MatchString(status) {
+			sl.ReportError(status, "ExpectedStatus", "expectedStatus", "status_range", "")
+		}
+	}
+	if check.BodyRegex != "" {
+		if _, err := regexp.Compile(check.BodyRegex); err != nil {
+			sl.ReportError(check.BodyRegex, "BodyRegex", "bodyRegex", "regexp", "")
+		}
+	}
+	if check.JSONValue != "" && check.JSONPath == "" {
+		sl.ReportError(check.JSONValue, "JSONValue", "jsonValue", "required_with", "JSONPath")
+	}
+	if check.Type == "send_expect" && check.Expect == "" {
+		sl.ReportError(check.Expect, "Expect", "expect", "required_with", "Type send_expect")
+	}
+	if check.Flap.Transitions > 0 && check.Flap.Window <= 0 {
+		sl.ReportError(check.Flap.Window, "Flap.Window", "window", "required_with", "Transitions")
+	}
+	// HEAD responses have no body to match
+	if check.Method == "HEAD" && (check.BodyRegex != "" || check.JSONPath != "") {
+		sl.ReportError(check.Method, "Method", "method", "excluded_with", "BodyRegex JSONPath")
+	}
+}